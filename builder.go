@@ -0,0 +1,212 @@
+package wtlfu
+
+import (
+	"time"
+
+	"wtlfu/internal"
+)
+
+// ConfigError is returned by Build when an option is invalid, use errors.As to inspect it.
+type ConfigError = internal.ConfigError
+
+// Builder collects the options of a Cache. Options left unset use the defaults
+// documented on each method; invalid values are reported by Build, never adjusted.
+type Builder[K comparable, V any] struct {
+	cfg internal.Config[K, V]
+}
+
+// NewBuilder returns a Builder for a cache holding at most capacity entries.
+func NewBuilder[K comparable, V any](capacity int) *Builder[K, V] {
+	return &Builder[K, V]{cfg: internal.Config[K, V]{Capacity: capacity}}
+}
+
+// WindowRatio sets the share of the capacity used by the admission window,
+// it must be in (0, 1). Default is 0.01.
+func (b *Builder[K, V]) WindowRatio(ratio float64) *Builder[K, V] {
+	b.cfg.WindowRatio = ratio
+	return b
+}
+
+// AdaptiveWindow lets the cache move capacity between the admission window
+// and the main cache at runtime, following the hit rate it samples: recency
+// heavy workloads get a larger window, frequency skewed ones a smaller one.
+// WindowRatio is then only the initial split. The current split is reported
+// by Cache.Split.
+func (b *Builder[K, V]) AdaptiveWindow() *Builder[K, V] {
+	b.cfg.AdaptiveWindow = true
+	return b
+}
+
+// ProbationRatio sets the share of the main cache used by the probation
+// segment, it must be in (0, 1). Default is 0.2.
+func (b *Builder[K, V]) ProbationRatio(ratio float64) *Builder[K, V] {
+	b.cfg.ProbationRatio = ratio
+	return b
+}
+
+// ShardCount sets the number of shards, it must be a power of two and small
+// enough to leave room for the main cache after every shard got its window.
+// Default is the next power of two above runtime.NumCPU(), reduced for small
+// capacities.
+func (b *Builder[K, V]) ShardCount(count int) *Builder[K, V] {
+	b.cfg.ShardCount = count
+	return b
+}
+
+// Doorkeeper sizes the bloom filter rejecting first-time writes of each shard:
+// it holds factor times the shard capacity with the given false positive rate.
+// Defaults are 20 and 0.01.
+func (b *Builder[K, V]) Doorkeeper(factor int, falsePositiveRate float64) *Builder[K, V] {
+	b.cfg.DoorkeeperFactor = factor
+	b.cfg.DoorkeeperFPR = falsePositiveRate
+	return b
+}
+
+// DoorkeeperMode tells what the doorkeeper bloom filter is used for, see
+// Builder.DoorkeeperMode.
+type DoorkeeperMode = internal.DoorkeeperMode
+
+const (
+	// DoorkeeperAdmission rejects the first write of every key that is not in
+	// the cache, so keys written once never displace anything. A value written
+	// once is not readable back, see SetOptions.Force.
+	DoorkeeperAdmission = internal.DoorkeeperAdmission
+	// DoorkeeperFrequency admits every write and uses the doorkeeper as in the
+	// TinyLFU paper: the first access of a key is kept by the doorkeeper and
+	// only the next ones are counted by the frequency sketch, so keys read once
+	// do not fill it.
+	DoorkeeperFrequency = internal.DoorkeeperFrequency
+	// DoorkeeperDisabled admits every write and counts every access.
+	DoorkeeperDisabled = internal.DoorkeeperDisabled
+)
+
+// DoorkeeperMode sets what the doorkeeper is used for, default is
+// DoorkeeperAdmission. The doorkeeper is sized by Builder.Doorkeeper in every
+// mode but DoorkeeperDisabled.
+func (b *Builder[K, V]) DoorkeeperMode(mode DoorkeeperMode) *Builder[K, V] {
+	b.cfg.DoorkeeperMode = mode
+	return b
+}
+
+// SampleSize sets the number of reads, hits and misses, after which every
+// counter of the frequency sketch is halved, so the admission policy follows a
+// shifting popularity instead of favouring keys that were popular once. The
+// doorkeeper is cleared at the same time, so first writes are forgotten too.
+// Default is 10 times the capacity.
+func (b *Builder[K, V]) SampleSize(size int) *Builder[K, V] {
+	b.cfg.SampleSize = size
+	return b
+}
+
+// SketchKind is the memory layout of the frequency sketch, see Builder.Sketch.
+type SketchKind = internal.SketchKind
+
+const (
+	// SketchRows keeps each of the 4 hash functions of the count-min sketch in
+	// its own array, so a read or an update touches 4 cache lines.
+	SketchRows = internal.SketchRows
+	// SketchBlocked keeps the 4 counters of a key in one 64-byte block, so a
+	// read or an update touches a single cache line. It uses the same memory.
+	SketchBlocked = internal.SketchBlocked
+)
+
+// Sketch sets the layout of the frequency sketch, default is SketchRows.
+func (b *Builder[K, V]) Sketch(kind SketchKind) *Builder[K, V] {
+	b.cfg.Sketch = kind
+	return b
+}
+
+// ConservativeUpdate makes the frequency sketch increment only the counters
+// of a key equal to its current estimate. Cold keys sharing counters with hot
+// keys are less overestimated, so they less often win the admission by luck.
+func (b *Builder[K, V]) ConservativeUpdate() *Builder[K, V] {
+	b.cfg.ConservativeUpdate = true
+	return b
+}
+
+// WriteBufferSize sets the number of pending writes buffered before Set blocks
+// on the maintenance goroutine. Default is capacity/100 clamped to [4, 1024].
+func (b *Builder[K, V]) WriteBufferSize(size int) *Builder[K, V] {
+	b.cfg.WriteBufferSize = size
+	return b
+}
+
+// TickInterval sets how often expired entries are cleaned up. Default is 500ms.
+func (b *Builder[K, V]) TickInterval(interval time.Duration) *Builder[K, V] {
+	b.cfg.TickInterval = interval
+	return b
+}
+
+// ExpireAfterWrite sets the ttl of entries written without one, including
+// the values stored by a LoadingCache. Default is 0, they never expire.
+func (b *Builder[K, V]) ExpireAfterWrite(ttl time.Duration) *Builder[K, V] {
+	b.cfg.ExpireAfterWrite = ttl
+	return b
+}
+
+// ExpireAfterAccess makes entries that were neither read nor written for d
+// expire, even if their ttl has not passed. They are removed with the Idle
+// reason rather than Expired. Default is 0, entries never expire when idle.
+func (b *Builder[K, V]) ExpireAfterAccess(d time.Duration) *Builder[K, V] {
+	b.cfg.ExpireAfterAccess = d
+	return b
+}
+
+// Expiry sets how the expiration of each entry is computed on create, update
+// and read. An explicit ttl given to SetWithTTL takes precedence over it. It
+// can not be combined with ExpireAfterWrite.
+func (b *Builder[K, V]) Expiry(expiry Expiry[K, V]) *Builder[K, V] {
+	b.cfg.Expiry = expiry
+	return b
+}
+
+// RemovalListener sets a function called whenever an entry leaves the cache.
+// It runs synchronously on the goroutine that removed the entry, so it should
+// return quickly.
+func (b *Builder[K, V]) RemovalListener(fn func(key K, value V, reason RemoveReason)) *Builder[K, V] {
+	b.cfg.RemovalListener = fn
+	return b
+}
+
+// NotifyOnClose makes Close call the removal listener with Closed for every
+// entry left in the cache, to release resources held by the values. Without
+// it those entries are dropped silently.
+func (b *Builder[K, V]) NotifyOnClose() *Builder[K, V] {
+	b.cfg.NotifyOnClose = true
+	return b
+}
+
+// RecordStats makes the cache count hits, misses, loads, removals and
+// admission rejections, see Cache.Stats. Stats are not recorded by default.
+func (b *Builder[K, V]) RecordStats() *Builder[K, V] {
+	return b.StatsCounter(NewStatsCounter())
+}
+
+// StatsCounter makes the cache record its events with counter, for instance
+// to forward them to a metrics library. Cache.Stats returns its Snapshot.
+func (b *Builder[K, V]) StatsCounter(counter StatsCounter) *Builder[K, V] {
+	b.cfg.StatsCounter = counter
+	return b
+}
+
+// Weigher bounds the cache by the total weight of its entries instead of their
+// number: fn returns the weight of an entry, e.g. its size in bytes, and the
+// sum of all weights is kept under maxWeight. The capacity given to NewBuilder
+// is then only the expected number of entries, used to size internal structures
+// such as the frequency sketch.
+// An entry heavier than the admission window skips it and competes directly
+// for the main cache, and one heavier than the main cache is never kept.
+func (b *Builder[K, V]) Weigher(maxWeight int64, fn func(key K, value V) uint32) *Builder[K, V] {
+	b.cfg.MaxWeight = maxWeight
+	b.cfg.Weigher = fn
+	return b
+}
+
+// Build validates the options and creates the cache.
+func (b *Builder[K, V]) Build() (*Cache[K, V], error) {
+	store, err := internal.NewStoreWithConfig(b.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache[K, V]{store: store}, nil
+}
@@ -0,0 +1,132 @@
+// Package wtlfu provides a concurrent in-memory cache using the Window-TinyLFU
+// admission and eviction policy.
+//
+// The cache is built through a Builder:
+//
+//	cache, err := wtlfu.NewBuilder[string, int](10000).Build()
+//	if err != nil {
+//		// handle err
+//	}
+//	cache.Set("foo", 1)
+//	v, ok := cache.Get("foo")
+package wtlfu
+
+import (
+	"context"
+	"time"
+
+	"wtlfu/internal"
+)
+
+// RemoveReason tells why an entry left the cache.
+type RemoveReason = internal.RemoveReason
+
+const (
+	// Removed means the entry was deleted by the caller.
+	Removed = internal.REMOVED
+	// Evicted means the entry was evicted by the policy to make room.
+	Evicted = internal.EVICTED
+	// Expired means the ttl of the entry has passed.
+	Expired = internal.EXPIRED
+	// Idle means the entry was not accessed within the ExpireAfterAccess duration.
+	Idle = internal.IDLE
+	// Closed means the cache was closed while the entry was in it, the removal
+	// listener is only called with it when Builder.NotifyOnClose is set.
+	Closed = internal.CLOSED
+)
+
+// ErrClosed is returned by Close when the cache is already closed, and by the
+// loading methods of a closed LoadingCache.
+var ErrClosed = internal.ErrClosed
+
+// Split is the capacity, or weight with a Weigher, given to the admission
+// window and the main cache. HitRate is the hit rate of the last sample taken
+// by the adaptive window, 0 if it is not enabled.
+type Split = internal.Split
+
+// Cache is a concurrent Window-TinyLFU cache, use NewBuilder to create one.
+type Cache[K comparable, V any] struct {
+	store *internal.Store[K, V]
+}
+
+// Get returns the value stored under key and whether it was found.
+// Expired entries are never returned.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	return c.store.Get(key)
+}
+
+// Set stores value under key, it expires as computed by the Expiry of the
+// builder, or after its ExpireAfterWrite ttl if one is set, and never otherwise.
+//
+// The first write of a key that is not in the cache may be rejected by the
+// admission doorkeeper, in which case Set returns false and the value is not
+// stored, see Builder.DoorkeeperMode and SetOptions.Force. Updating an
+// existing key always succeeds, unless the cache is closed.
+func (c *Cache[K, V]) Set(key K, value V) bool {
+	return c.store.Set(key, value, 0)
+}
+
+// SetWithTTL is like Set, but the entry expires once ttl has passed.
+// A ttl <= 0 behaves like Set, except that an update of an entry that has not
+// expired yet keeps its previous expiration when neither Expiry nor
+// ExpireAfterWrite is set.
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) bool {
+	return c.store.Set(key, value, ttl)
+}
+
+// Delete removes key from the cache, the removal listener is called with Removed.
+func (c *Cache[K, V]) Delete(key K) {
+	c.store.Delete(key)
+}
+
+// Len returns the number of entries in the cache. It may include expired
+// entries that have not been cleaned up yet.
+func (c *Cache[K, V]) Len() int {
+	return c.store.Len()
+}
+
+// Split returns how the capacity is currently split between the admission
+// window and the main cache, see Builder.AdaptiveWindow.
+func (c *Cache[K, V]) Split() Split {
+	return c.store.Split()
+}
+
+// Tick removes the entries expired according to the clock now, and calls the
+// removal listener for them, instead of waiting for the next maintenance tick.
+// The writes made before the call are applied first, so an entry written and
+// then expired by advancing a FakeClock is removed by a single Tick.
+func (c *Cache[K, V]) Tick() {
+	c.store.Tick()
+}
+
+// Cleanup runs the pending maintenance before returning: the writes made
+// before the call are applied to the policy, so evicted entries are removed
+// and their removal listener called, the buffered reads are counted, and the
+// entries expired according to the clock are removed.
+//
+// Maintenance otherwise runs in the background, so the state of the cache
+// after a write, like which entry was evicted, is only known after Cleanup.
+// Without concurrent calls, a cache built with a fixed Seed then behaves the
+// same in every run, which makes policy tests and simulations reproducible.
+// Cleanup does nothing once the cache is closed.
+func (c *Cache[K, V]) Cleanup() {
+	c.store.Cleanup()
+}
+
+// Close shuts the cache down gracefully. The writes made before Close are
+// applied, then Close returns once the background goroutines have exited, and
+// every entry is removed with the reason Closed.
+//
+// Once Close started, the cache rejects every operation: Set and SetWithTTL
+// return false, SetWithOptions returns SetClosed, Get misses, Delete leaves
+// the entry to Close, which reports it as Closed, and Tick and Cleanup do
+// nothing. The loading methods of a LoadingCache return ErrClosed, including
+// a load that finishes after Close started.
+//
+// If ctx is done before the goroutines exit, Close returns the error of ctx
+// and the entries are left in place; the goroutines still exit on their own.
+// Calling Close again waits for them and then removes the entries. Once a
+// Close has removed the entries, closing the cache again returns ErrClosed.
+func (c *Cache[K, V]) Close(ctx context.Context) error {
+	return c.store.Close(ctx)
+}
@@ -0,0 +1,669 @@
+package wtlfu_test
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"wtlfu"
+)
+
+// set writes key twice so the first-write doorkeeper never rejects it
+func set[K comparable, V any](c *wtlfu.Cache[K, V], key K, value V) bool {
+	c.Set(key, value)
+	return c.Set(key, value)
+}
+
+func TestBuild_Invalid(t *testing.T) {
+	for _, c := range []struct {
+		field   string
+		builder *wtlfu.Builder[string, int]
+	}{
+		{"Capacity", wtlfu.NewBuilder[string, int](0)},
+		{"Capacity", wtlfu.NewBuilder[string, int](10).ShardCount(16)},
+		{"WindowRatio", wtlfu.NewBuilder[string, int](100).WindowRatio(1)},
+		{"ProbationRatio", wtlfu.NewBuilder[string, int](100).ProbationRatio(-0.1)},
+		{"ShardCount", wtlfu.NewBuilder[string, int](100).ShardCount(3)},
+		{"DoorkeeperFPR", wtlfu.NewBuilder[string, int](100).Doorkeeper(10, 1.5)},
+		{"DoorkeeperMode", wtlfu.NewBuilder[string, int](100).DoorkeeperMode(9)},
+		{"SampleSize", wtlfu.NewBuilder[string, int](100).SampleSize(-1)},
+		{"Sketch", wtlfu.NewBuilder[string, int](100).Sketch(7)},
+		{"WriteBufferSize", wtlfu.NewBuilder[string, int](100).WriteBufferSize(-1)},
+		{"TickInterval", wtlfu.NewBuilder[string, int](100).TickInterval(-time.Second)},
+		{"MaxWeight", wtlfu.NewBuilder[string, int](100).Weigher(0, func(string, int) uint32 { return 1 })},
+		{"Expiry", wtlfu.NewBuilder[string, int](100).Expiry(testExpiry{}).ExpireAfterWrite(time.Second)},
+		{"MaxWeight", wtlfu.NewBuilder[string, int](100).ShardCount(4).Weigher(4, func(string, int) uint32 { return 1 })},
+	} {
+		_, err := c.builder.Build()
+		var cfgErr *wtlfu.ConfigError
+		require.ErrorAs(t, err, &cfgErr)
+		require.Equal(t, c.field, cfgErr.Field)
+	}
+}
+
+type user struct {
+	Org  int
+	Name string
+}
+
+type userHasher struct{}
+
+func (userHasher) Hash(u user) uint64 {
+	return uint64(u.Org)<<32 ^ uint64(len(u.Name))
+}
+
+type hashableUser user
+
+func (u hashableUser) Hash() uint64 {
+	return userHasher{}.Hash(user(u))
+}
+
+func TestBuild_Hasher(t *testing.T) {
+	_, err := wtlfu.NewBuilder[user, int](100).Build()
+	var cfgErr *wtlfu.ConfigError
+	require.ErrorAs(t, err, &cfgErr)
+	require.Equal(t, "Hasher", cfgErr.Field)
+
+	// keys built at runtime do not share the memory of their names
+	name := strings.Repeat("a", 3)
+	key := user{1, strings.Repeat("a", 3)}
+
+	cache, err := wtlfu.NewBuilder[user, int](100).Hasher(userHasher{}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+	require.True(t, set(cache, key, 1))
+	v, ok := cache.Get(user{1, name})
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	hashable, err := wtlfu.NewBuilder[hashableUser, int](100).Build()
+	require.Nil(t, err)
+	defer hashable.Close(context.Background())
+	require.True(t, set(hashable, hashableUser(key), 1))
+	_, ok = hashable.Get(hashableUser{1, name})
+	require.True(t, ok)
+}
+
+func TestBuild_Options(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](1000).
+		WindowRatio(0.1).
+		ProbationRatio(0.5).
+		ShardCount(4).
+		Doorkeeper(10, 0.001).
+		Sketch(wtlfu.SketchBlocked).
+		ConservativeUpdate().
+		Seed(42).
+		WriteBufferSize(1).
+		TickInterval(10 * time.Millisecond).
+		Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	require.True(t, set(cache, "foo", 1))
+	v, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+}
+
+func TestCache_SetGetDelete(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	_, ok := cache.Get("foo")
+	require.False(t, ok)
+
+	require.True(t, set(cache, "foo", 1))
+	v, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	require.Equal(t, 1, cache.Len())
+
+	require.True(t, cache.Set("foo", 2))
+	v, _ = cache.Get("foo")
+	require.Equal(t, 2, v)
+
+	cache.Delete("foo")
+	_, ok = cache.Get("foo")
+	require.False(t, ok)
+	require.Equal(t, 0, cache.Len())
+}
+
+func TestCache_DoorkeeperMode(t *testing.T) {
+	for _, mode := range []wtlfu.DoorkeeperMode{wtlfu.DoorkeeperFrequency, wtlfu.DoorkeeperDisabled} {
+		cache, err := wtlfu.NewBuilder[string, int](100).DoorkeeperMode(mode).Build()
+		require.Nil(t, err)
+
+		require.True(t, cache.Set("foo", 1), mode.String())
+		v, ok := cache.Get("foo")
+		require.True(t, ok, mode.String())
+		require.Equal(t, 1, v)
+		require.True(t, cache.Explain("bar").DoorkeeperSeen, mode.String())
+		cache.Close(context.Background())
+	}
+}
+
+func TestCache_SetWithTTL(t *testing.T) {
+	var mu sync.Mutex
+	expired := map[string]int{}
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		RemovalListener(func(key string, value int, reason wtlfu.RemoveReason) {
+			if reason == wtlfu.Expired {
+				mu.Lock()
+				expired[key] = value
+				mu.Unlock()
+			}
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	cache.SetWithTTL("foo", 1, 50*time.Millisecond)
+	require.True(t, cache.SetWithTTL("foo", 1, 50*time.Millisecond))
+	_, ok := cache.Get("foo")
+	require.True(t, ok)
+
+	time.Sleep(100 * time.Millisecond)
+	_, ok = cache.Get("foo")
+	require.False(t, ok)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return expired["foo"] == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, 0, cache.Len())
+}
+
+func TestCache_FakeClock(t *testing.T) {
+	var mu sync.Mutex
+	reasons := map[string]wtlfu.RemoveReason{}
+	clock := wtlfu.NewFakeClock(time.Now())
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Clock(clock).
+		ExpireAfterAccess(time.Hour).
+		RemovalListener(func(key string, value int, reason wtlfu.RemoveReason) {
+			mu.Lock()
+			reasons[key] = reason
+			mu.Unlock()
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	cache.SetWithTTL("ttl", 1, time.Minute)
+	cache.SetWithTTL("ttl", 1, time.Minute)
+	set(cache, "idle", 1)
+	set(cache, "read", 1)
+	for i := 0; i < 4; i++ {
+		clock.Advance(20 * time.Minute)
+		_, ok := cache.Get("read")
+		require.True(t, ok)
+	}
+	_, ok := cache.Get("ttl")
+	require.False(t, ok)
+	_, ok = cache.Get("idle")
+	require.False(t, ok)
+
+	cache.Tick()
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, map[string]wtlfu.RemoveReason{"ttl": wtlfu.Expired, "idle": wtlfu.Idle}, reasons)
+}
+
+func TestCache_TickPendingWrite(t *testing.T) {
+	clock := wtlfu.NewFakeClock(time.Now())
+	cache, err := wtlfu.NewBuilder[string, int](100).Clock(clock).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	// the write may still be buffered, and its deadline is already past when it is applied
+	cache.SetWithOptions("foo", 1, wtlfu.SetOptions{TTL: time.Minute, Force: true})
+	clock.Advance(2 * time.Minute)
+	cache.Tick()
+	require.Equal(t, 0, cache.Len())
+
+	// the clock does not move between the write and the Tick
+	cache.SetWithOptions("bar", 1, wtlfu.SetOptions{TTL: time.Nanosecond, Force: true})
+	clock.Advance(time.Millisecond)
+	cache.Tick()
+	cache.SetWithOptions("baz", 1, wtlfu.SetOptions{TTL: time.Nanosecond, Force: true})
+	clock.Advance(time.Nanosecond)
+	cache.Tick()
+	require.Equal(t, 0, cache.Len())
+}
+
+func TestCache_Cleanup(t *testing.T) {
+	var evicted []int
+	clock := wtlfu.NewFakeClock(time.Now())
+	cache, err := wtlfu.NewBuilder[int, int](100).
+		ShardCount(1).
+		DoorkeeperMode(wtlfu.DoorkeeperDisabled).
+		Clock(clock).
+		Seed(1).
+		RemovalListener(func(key int, value int, reason wtlfu.RemoveReason) {
+			// listeners run on the goroutine removing the entry, Cleanup waits for it
+			evicted = append(evicted, key)
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	// the window holds one entry, the main cache the 99 others
+	for k := 0; k < 100; k++ {
+		cache.Set(k, k)
+	}
+	cache.Cleanup()
+	require.Equal(t, 100, cache.Len())
+	require.Empty(t, evicted)
+
+	// 100 pushes 99 out of the window, which is not more frequent than the victim 0
+	cache.Set(100, 100)
+	cache.Cleanup()
+	require.Equal(t, []int{99}, evicted)
+
+	// misses count: 200 pushes 100 out, which loses too, but 200 beats the victim 0
+	// once 201 pushes it out of the window
+	for i := 0; i < 5; i++ {
+		cache.Get(200)
+	}
+	cache.Set(200, 200)
+	cache.Cleanup()
+	require.Equal(t, []int{99, 100}, evicted)
+	cache.Set(201, 201)
+	cache.Cleanup()
+	require.Equal(t, []int{99, 100, 0}, evicted)
+	require.Equal(t, wtlfu.StageProbation, cache.Explain(200).Stage)
+
+	cache.SetWithTTL(1, 1, time.Minute)
+	clock.Advance(time.Hour)
+	cache.Cleanup()
+	require.Equal(t, []int{99, 100, 0, 1}, evicted)
+}
+
+// testExpiry expires an entry after value milliseconds, keeps it on update and
+// extends it to 100ms on read when read is set
+type testExpiry struct {
+	read bool
+}
+
+func (testExpiry) ExpireAfterCreate(key string, value int) time.Duration {
+	return time.Duration(value) * time.Millisecond
+}
+
+func (testExpiry) ExpireAfterUpdate(key string, value int, currentDuration time.Duration) time.Duration {
+	return currentDuration
+}
+
+func (e testExpiry) ExpireAfterRead(key string, value int, currentDuration time.Duration) time.Duration {
+	if e.read {
+		return 100 * time.Millisecond
+	}
+	return currentDuration
+}
+
+func TestCache_Expiry(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).Expiry(testExpiry{}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	set(cache, "never", 0)
+	set(cache, "short", 50)
+	// an update keeps the expiration computed on create
+	set(cache, "update", 50)
+	cache.Set("update", 10000)
+	// an explicit ttl takes precedence
+	cache.SetWithTTL("ttl", 50, time.Hour)
+	require.True(t, cache.SetWithTTL("ttl", 50, time.Hour))
+
+	time.Sleep(100 * time.Millisecond)
+	_, ok := cache.Get("never")
+	require.True(t, ok)
+	_, ok = cache.Get("short")
+	require.False(t, ok)
+	_, ok = cache.Get("update")
+	require.False(t, ok)
+	_, ok = cache.Get("ttl")
+	require.True(t, ok)
+}
+
+func TestCache_ExpiryRead(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).Expiry(testExpiry{read: true}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	set(cache, "foo", 100)
+	set(cache, "bar", 100)
+	// every read extends foo by 100ms, bar is never read
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, ok := cache.Get("foo")
+		require.True(t, ok)
+	}
+	_, ok := cache.Get("bar")
+	require.False(t, ok)
+}
+
+func TestCache_ExpireAfterAccess(t *testing.T) {
+	var mu sync.Mutex
+	reasons := map[string]wtlfu.RemoveReason{}
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		ExpireAfterAccess(100 * time.Millisecond).
+		TickInterval(10 * time.Millisecond).
+		RemovalListener(func(key string, value int, reason wtlfu.RemoveReason) {
+			mu.Lock()
+			reasons[key] = reason
+			mu.Unlock()
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	set(cache, "read", 1)
+	set(cache, "idle", 1)
+	cache.SetWithTTL("ttl", 1, 20*time.Millisecond)
+	cache.SetWithTTL("ttl", 1, 20*time.Millisecond)
+	// reads keep an entry alive past the idle duration
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, ok := cache.Get("read")
+		require.True(t, ok)
+	}
+	_, ok := cache.Get("idle")
+	require.False(t, ok)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return reasons["idle"] == wtlfu.Idle && reasons["ttl"] == wtlfu.Expired
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCache_RemovalListener(t *testing.T) {
+	var mu sync.Mutex
+	reasons := map[wtlfu.RemoveReason]int{}
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		RemovalListener(func(key string, value int, reason wtlfu.RemoveReason) {
+			mu.Lock()
+			reasons[reason]++
+			mu.Unlock()
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	set(cache, "foo", 1)
+	cache.Delete("foo")
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return reasons[wtlfu.Removed] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 1000; i++ {
+		set(cache, strconv.Itoa(i), i)
+	}
+	require.Eventually(t, func() bool {
+		return cache.Len() <= 100
+	}, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	require.Greater(t, reasons[wtlfu.Evicted], 0)
+	mu.Unlock()
+}
+
+func TestCache_Weigher(t *testing.T) {
+	var mu sync.Mutex
+	evicted := map[string]bool{}
+	cache, err := wtlfu.NewBuilder[string, string](100).
+		ShardCount(1).
+		Weigher(1000, func(key string, value string) uint32 { return uint32(len(value)) }).
+		RemovalListener(func(key string, value string, reason wtlfu.RemoveReason) {
+			if reason == wtlfu.Evicted {
+				mu.Lock()
+				evicted[key] = true
+				mu.Unlock()
+			}
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+	isEvicted := func(key string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return evicted[key]
+		}
+	}
+
+	// the weight bounds the cache, 100 entries of 50 bytes do not fit in 1000
+	value := strings.Repeat("x", 50)
+	for i := 0; i < 100; i++ {
+		set(cache, strconv.Itoa(i), value)
+	}
+	require.Eventually(t, func() bool {
+		return cache.Len() <= 1000/50
+	}, time.Second, 10*time.Millisecond)
+
+	// an entry heavier than the whole cache is never kept
+	set(cache, "huge", strings.Repeat("x", 1001))
+	require.Eventually(t, isEvicted("huge"), time.Second, 10*time.Millisecond)
+	_, ok := cache.Get("huge")
+	require.False(t, ok)
+
+	// an update that makes an entry too heavy evicts it
+	set(cache, "grow", "x")
+	require.True(t, cache.Set("grow", strings.Repeat("x", 1001)))
+	require.Eventually(t, isEvicted("grow"), time.Second, 10*time.Millisecond)
+	_, ok = cache.Get("grow")
+	require.False(t, ok)
+}
+
+func TestCache_AdaptiveWindow(t *testing.T) {
+	fixed, err := wtlfu.NewBuilder[int, int](1000).ShardCount(1).Build()
+	require.Nil(t, err)
+	defer fixed.Close(context.Background())
+	adaptive, err := wtlfu.NewBuilder[int, int](1000).ShardCount(1).AdaptiveWindow().Build()
+	require.Nil(t, err)
+	defer adaptive.Close(context.Background())
+	initial := adaptive.Split()
+	require.Equal(t, wtlfu.Split{Window: 10, Main: 990, Protected: 792}, initial)
+
+	// a recency heavy workload, every key is read right after it is written
+	for _, cache := range []*wtlfu.Cache[int, int]{fixed, adaptive} {
+		for i := 0; i < 5000; i++ {
+			set(cache, i, i)
+			for j := 0; j < 50 && j <= i; j++ {
+				cache.Get(i - j)
+			}
+		}
+	}
+	require.Equal(t, initial, fixed.Split())
+	split := adaptive.Split()
+	require.Greater(t, split.Window, initial.Window)
+	require.Equal(t, 1000, split.Window+split.Main)
+	require.Greater(t, split.HitRate, 0.0)
+	require.Eventually(t, func() bool {
+		return adaptive.Len() <= 1000
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCache_ShiftingPopularity(t *testing.T) {
+	// hitRatio reads through a hot set of 80 keys, then through another one,
+	// and returns the hit ratio of the last pass over the second set
+	hitRatio := func(builder *wtlfu.Builder[int, int]) float64 {
+		cache, err := builder.ShardCount(1).Build()
+		require.Nil(t, err)
+		defer cache.Close(context.Background())
+
+		hits := 0
+		for _, first := range []int{0, 1000} {
+			for pass := 0; pass < 40; pass++ {
+				hits = 0
+				for k := first; k < first+80; k++ {
+					if _, ok := cache.Get(k); ok {
+						hits++
+					} else {
+						cache.SetWithOptions(k, k, wtlfu.SetOptions{Force: true})
+					}
+				}
+			}
+		}
+		return float64(hits) / 80
+	}
+
+	// the first hot set saturates its counters, without aging the second one
+	// only wins the admission against it by chance
+	fresh := hitRatio(wtlfu.NewBuilder[int, int](100))
+	stale := hitRatio(wtlfu.NewBuilder[int, int](100).SampleSize(1 << 30))
+	require.Greater(t, fresh, 0.7)
+	require.Less(t, stale, 0.6)
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[int, int](1000).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				key := (i * (g + 1)) % 2000
+				if v, ok := cache.Get(key); ok {
+					require.Equal(t, key, v)
+				} else {
+					cache.Set(key, key)
+				}
+				if i%100 == 0 {
+					cache.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool {
+		return cache.Len() <= 1000
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCache_Close(t *testing.T) {
+	closed := map[int]int{}
+	cache, err := wtlfu.NewBuilder[int, int](100).
+		RecordStats().
+		NotifyOnClose().
+		RemovalListener(func(key int, value int, reason wtlfu.RemoveReason) {
+			require.Equal(t, wtlfu.Closed, reason)
+			closed[key] = value
+		}).Build()
+	require.Nil(t, err)
+
+	for k := 0; k < 10; k++ {
+		require.Equal(t, wtlfu.SetAdmitted, cache.SetWithOptions(k, k, wtlfu.SetOptions{Force: true}))
+	}
+	require.Nil(t, cache.Close(context.Background()))
+	require.Len(t, closed, 10)
+	require.Equal(t, uint64(10), cache.Stats().Removed(wtlfu.Closed))
+
+	require.Equal(t, 0, cache.Len())
+	_, ok := cache.Get(1)
+	require.False(t, ok)
+	require.False(t, set(cache, 1, 1))
+	require.Equal(t, wtlfu.SetClosed, cache.SetWithOptions(1, 1, wtlfu.SetOptions{Force: true}))
+	cache.Delete(1)
+	cache.Cleanup()
+	require.ErrorIs(t, cache.Close(context.Background()), wtlfu.ErrClosed)
+}
+
+func TestCache_CloseConcurrentWrites(t *testing.T) {
+	var mu sync.Mutex
+	var closed, afterClose int
+	cache, err := wtlfu.NewBuilder[int, int](100).
+		NotifyOnClose().
+		RemovalListener(func(key int, value int, reason wtlfu.RemoveReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			if reason == wtlfu.Closed {
+				closed++
+			} else if closed > 0 {
+				afterClose++
+			}
+		}).Build()
+	require.Nil(t, err)
+
+	// writers racing with Close must neither panic nor store entries after it
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := i * 1000; k < (i+1)*1000; k++ {
+				cache.SetWithOptions(k, k, wtlfu.SetOptions{Force: true})
+				cache.Get(k - 1)
+			}
+		}(i)
+	}
+	time.Sleep(time.Millisecond)
+	require.Nil(t, cache.Close(context.Background()))
+	wg.Wait()
+	cache.Cleanup()
+	require.Equal(t, 0, cache.Len())
+	require.Greater(t, closed, 0)
+	require.Equal(t, 0, afterClose)
+}
+
+func TestCache_CloseGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			return strconv.Atoi(key)
+		}).
+		RefreshAfterWrite(time.Minute).
+		Build()
+	require.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		_, err := cache.GetOrLoad(context.Background(), strconv.Itoa(i))
+		require.Nil(t, err)
+	}
+	require.Greater(t, runtime.NumGoroutine(), before)
+
+	require.Nil(t, cache.Close(context.Background()))
+	// the goroutines have returned, they may not be reaped yet. The ticker would
+	// otherwise only stop at its next tick, 500ms later. Eventually is not used
+	// because it runs goroutines of its own
+	for deadline := time.Now().Add(100 * time.Millisecond); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+	_, err = cache.GetOrLoad(context.Background(), "1")
+	require.ErrorIs(t, err, wtlfu.ErrClosed)
+	_, err = cache.GetAll(context.Background(), []string{"1"})
+	require.ErrorIs(t, err, wtlfu.ErrClosed)
+}
+
+func TestCache_CleanupExpires(t *testing.T) {
+	for _, ttl := range []time.Duration{time.Second, 90 * time.Second} {
+		var expired int
+		clock := wtlfu.NewFakeClock(time.Now())
+		cache, err := wtlfu.NewBuilder[int, int](1000).
+			Clock(clock).
+			RemovalListener(func(key int, value int, reason wtlfu.RemoveReason) {
+				if reason == wtlfu.Expired {
+					expired++
+				}
+			}).Build()
+		require.Nil(t, err)
+
+		for k := 0; k < 500; k++ {
+			cache.SetWithOptions(k, k, wtlfu.SetOptions{TTL: ttl + time.Duration(k)*time.Millisecond, Force: true})
+		}
+		cache.Cleanup()
+		clock.Advance(ttl * 3 / 2)
+		// a single Cleanup removes every entry past its expiration
+		cache.Cleanup()
+		require.Equal(t, 500, expired, "ttl %v", ttl)
+		require.Equal(t, 0, cache.Len(), "ttl %v", ttl)
+		require.Nil(t, cache.Close(context.Background()))
+	}
+}
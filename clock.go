@@ -0,0 +1,33 @@
+package wtlfu
+
+import (
+	"time"
+
+	"wtlfu/internal"
+)
+
+// Clock tells the time used by expiration, see Builder.Clock. It must be safe
+// for concurrent use.
+type Clock = internal.Clock
+
+// SystemClock is the default Clock, the time of the system.
+type SystemClock = internal.SystemClock
+
+// FakeClock is a Clock that only moves when told to, so tests can expire
+// entries without sleeping. Its Advance and Set methods move it forward.
+type FakeClock = internal.FakeClock
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return internal.NewFakeClock(now)
+}
+
+// Clock sets the clock telling when entries expire, default is SystemClock.
+//
+// With a FakeClock an entry past its expiration is no longer returned as soon
+// as the clock is advanced, but it is only removed, and the removal listener
+// only called, by the next maintenance tick, see Cache.Tick.
+func (b *Builder[K, V]) Clock(clock Clock) *Builder[K, V] {
+	b.cfg.Clock = clock
+	return b
+}
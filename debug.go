@@ -0,0 +1,102 @@
+package wtlfu
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"wtlfu/internal"
+)
+
+const (
+	defaultDebugKeys = 10
+	maxDebugKeys     = 1000
+)
+
+// DebugHandler returns an http.Handler rendering the internals of the cache
+// as an HTML page: the length and first keys of the window of each shard and
+// of the probation and protected segments, the doorkeeper mode and the fill
+// ratio of the doorkeeper in use, which is per shard in DoorkeeperAdmission
+// mode and global in DoorkeeperFrequency mode, the occupancy of each timer
+// wheel bucket and the pending read and write buffer depths.
+//
+// The query parameter n sets how many keys of each segment are shown, 10 by
+// default. The query parameter key is parsed with parseKey, which may be nil,
+// and its sketch frequency estimate is shown.
+//
+// Each shard is locked only while its window is copied, and the main cache
+// only while its first keys and the timer wheel are copied, so a page stalls
+// the cache no longer than a few hundred list steps.
+func (c *Cache[K, V]) DebugHandler(parseKey func(string) (K, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := debugPage[K]{TopN: defaultDebugKeys}
+		if s := r.URL.Query().Get("n"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 || n > maxDebugKeys {
+				http.Error(w, "n must be an integer in [0, 1000]", http.StatusBadRequest)
+				return
+			}
+			page.TopN = n
+		}
+		var key K
+		var hasKey bool
+		if s := r.URL.Query().Get("key"); s != "" && parseKey != nil {
+			var err error
+			if key, err = parseKey(s); err != nil {
+				page.KeyError = err.Error()
+			} else {
+				page.Key, hasKey = s, true
+			}
+		}
+		page.DebugSnapshot = c.store.Debug(page.TopN, key, hasKey)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := debugTemplate.Execute(w, page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+type debugPage[K comparable] struct {
+	internal.DebugSnapshot[K]
+	TopN     int
+	Key      string
+	KeyError string
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head><title>wtlfu debug</title></head>
+<body>
+<h1>wtlfu debug</h1>
+<form><input name="key" placeholder="key" value="{{.Key}}"> <input name="n" value="{{.TopN}}" size="4"> <input type="submit" value="Show"></form>
+{{if .KeyError}}<p>invalid key: {{.KeyError}}</p>{{end}}
+{{if .Key}}<p>sketch frequency of {{.Key}}: {{.Frequency}}</p>{{end}}
+
+<h2>Buffers</h2>
+<p>pending reads: {{.ReadBuffer}}, pending writes: {{.WriteBuffer}}</p>
+
+<h2>Doorkeeper</h2>
+<p>mode: {{.DoorkeeperMode}}{{if eq .DoorkeeperMode.String "admission"}}, filtering first writes, fill per shard below{{end}}{{if eq .DoorkeeperMode.String "frequency"}}, filtering sketch increments, fill: {{printf "%.4f" .PolicyDoorkeeperFill}}{{end}}</p>
+
+<h2>Main cache</h2>
+<table border="1">
+<tr><th>segment</th><th>length</th><th>first keys, most recent first</th></tr>
+<tr><td>probation</td><td>{{.ProbationLen}}</td><td>{{range .ProbationKeys}}{{.}} {{end}}</td></tr>
+<tr><td>protected</td><td>{{.ProtectedLen}}</td><td>{{range .ProtectedKeys}}{{.}} {{end}}</td></tr>
+</table>
+
+<h2>Shards</h2>
+<table border="1">
+<tr><th>shard</th><th>entries</th><th>window length</th><th>admission doorkeeper fill</th><th>first window keys, most recent first</th></tr>
+{{range $i, $s := .Shards}}<tr><td>{{$i}}</td><td>{{$s.Len}}</td><td>{{$s.WindowLen}}</td><td>{{printf "%.4f" $s.DoorkeeperFill}}</td><td>{{range $s.WindowKeys}}{{.}} {{end}}</td></tr>
+{{end}}</table>
+
+<h2>Timer wheel</h2>
+<table border="1">
+<tr><th>level</th><th>items per bucket</th></tr>
+{{range $i, $l := .TimerWheel}}<tr><td>{{$i}}</td><td>{{range $l}}{{.}} {{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,70 @@
+package wtlfu_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"wtlfu"
+)
+
+func TestCache_DebugHandler(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[int, int](1000).ShardCount(2).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+	for i := 0; i < 100; i++ {
+		set(cache, i, i)
+	}
+	cache.SetWithTTL(1000, 1, time.Hour)
+	cache.SetWithTTL(1000, 1, time.Hour)
+	for i := 0; i < 200; i++ {
+		cache.Get(7)
+	}
+	handler := cache.DebugHandler(strconv.Atoi)
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		return rec
+	}
+
+	// key 7 was read enough to be promoted to the protected segment
+	require.Eventually(t, func() bool {
+		return strings.Contains(get("/").Body.String(), "<td>protected</td><td>1</td><td>7 </td>")
+	}, time.Second, 10*time.Millisecond)
+
+	rec := get("/?key=7&n=3")
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	require.Contains(t, body, "sketch frequency of 7: ")
+	require.NotContains(t, body, "sketch frequency of 7: 0<")
+	// the last entry written is first in the window of its shard, it is also in the timer wheel
+	require.Regexp(t, `<tr><td>\d</td><td>\d+</td><td>\d+</td><td>0\.\d+</td><td>1000 \d+ \d+ </td></tr>`, body)
+	require.Regexp(t, `<tr><td>1</td><td>[0 ]*1[0 ]*</td></tr>`, body)
+
+	require.Contains(t, body, "mode: admission, filtering first writes")
+
+	require.Contains(t, get("/?key=foo").Body.String(), "invalid key")
+	require.Equal(t, http.StatusBadRequest, get("/?n=-1").Code)
+}
+
+func TestCache_DebugHandlerFrequencyDoorkeeper(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[int, int](1000).DoorkeeperMode(wtlfu.DoorkeeperFrequency).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+	// reads, misses too, reach the frequency doorkeeper
+	for i := 0; i < 100; i++ {
+		cache.Get(i)
+	}
+	cache.Cleanup()
+
+	// the shards have no doorkeeper, the fill shown is the one of the policy
+	rec := httptest.NewRecorder()
+	cache.DebugHandler(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	require.Regexp(t, `mode: frequency, filtering sketch increments, fill: 0\.\d*[1-9]`, rec.Body.String())
+}
@@ -0,0 +1,20 @@
+package wtlfu
+
+import "time"
+
+// Expiry computes the expiration of each entry from its key and value, for
+// instance to honor the max-age a backend returned along with a value.
+//
+// Every hook returns the time left before the entry expires, a duration <= 0
+// means the entry never expires. currentDuration is the time the entry had
+// left, or 0 if it did not expire, so returning it keeps the expiration as is.
+// The hooks run while the entry is locked and must not use the cache.
+type Expiry[K comparable, V any] interface {
+	// ExpireAfterCreate is called when a key missing from the cache, or
+	// expired, is written.
+	ExpireAfterCreate(key K, value V) time.Duration
+	// ExpireAfterUpdate is called when the value of an entry is replaced.
+	ExpireAfterUpdate(key K, value V, currentDuration time.Duration) time.Duration
+	// ExpireAfterRead is called when an entry is read by a hit.
+	ExpireAfterRead(key K, value V, currentDuration time.Duration) time.Duration
+}
@@ -0,0 +1,100 @@
+package wtlfu
+
+import (
+	"time"
+
+	"wtlfu/internal"
+)
+
+// SetResult tells what happened to a write, see Cache.SetWithOptions.
+type SetResult = internal.SetResult
+
+const (
+	// SetRejected means the doorkeeper had not seen the key before, the value
+	// was not stored. A second write of the key soon after is admitted.
+	SetRejected = internal.SetRejected
+	// SetAdmitted means a new entry was stored in the admission window.
+	SetAdmitted = internal.SetAdmitted
+	// SetUpdated means the value of an existing entry was replaced.
+	SetUpdated = internal.SetUpdated
+	// SetCandidate means a new entry heavier than the admission window was
+	// stored and sent straight to the main cache admission, use Explain to
+	// find out whether it won.
+	SetCandidate = internal.SetCandidate
+	// SetClosed means the cache is closed, the value was not stored.
+	SetClosed = internal.SetClosed
+)
+
+// SetOptions are the options of Cache.SetWithOptions.
+type SetOptions struct {
+	// TTL is the ttl of the entry, as in SetWithTTL.
+	TTL time.Duration
+	// Force skips the doorkeeper, so a new entry is always stored in the
+	// admission window, as a loaded value is. Its doorkeeper bits are still
+	// set.
+	Force bool
+}
+
+// SetWithOptions is like SetWithTTL, but reports what happened to the write.
+// Whether an entry leaving the window is kept by the main cache is decided
+// later, by the background maintenance, see Explain.
+func (c *Cache[K, V]) SetWithOptions(key K, value V, opts SetOptions) SetResult {
+	return c.store.SetWithResult(key, value, opts.TTL, opts.Force)
+}
+
+// Stage is where an entry is in the Window-TinyLFU policy.
+type Stage = internal.Stage
+
+const (
+	// StageAbsent means the key is not in the cache.
+	StageAbsent = internal.StageAbsent
+	// StageWindow means the entry is in the admission window.
+	StageWindow = internal.StageWindow
+	// StageCandidate means the entry left the window and waits for the
+	// admission decision of the main cache.
+	StageCandidate = internal.StageCandidate
+	// StageProbation means the entry is in the probation segment of the main cache.
+	StageProbation = internal.StageProbation
+	// StageProtected means the entry is in the protected segment of the main cache.
+	StageProtected = internal.StageProtected
+)
+
+// Explanation tells where a key is in the policy and why, see Cache.Explain.
+type Explanation[K comparable] struct {
+	// Stage is where the key currently is.
+	Stage Stage
+	// Frequency is the sketch estimate of the key.
+	Frequency int64
+	// DoorkeeperSeen reports whether the doorkeeper has seen the key, so its
+	// next first write is not rejected.
+	DoorkeeperSeen bool
+	// HasDuel reports whether the last main cache admission of the key is
+	// still remembered, the fields below describe it. Only the last 1024
+	// admissions of the cache are remembered.
+	HasDuel bool
+	// Admitted reports whether the key entered the main cache.
+	Admitted bool
+	// Random reports that the key did not beat the victim but was admitted
+	// by chance. A key read at least 6 times recently that loses is still
+	// admitted once in 128 times, so a victim whose frequency is inflated,
+	// by chance or by an attacker, can not reject every candidate forever.
+	Random bool
+	// HasVictim reports whether the key had to beat a victim, it does not when
+	// the main cache had room for it.
+	HasVictim bool
+	// Victim is the probation entry the key was compared with.
+	Victim K
+	// DuelFrequency and VictimFrequency are the sketch estimates of the key
+	// and of the victim compared by the admission, the key is admitted when
+	// its frequency is higher, or by chance, see Random.
+	DuelFrequency   int64
+	VictimFrequency int64
+}
+
+// Explain returns where key is in the policy, and the last admission of the
+// key to the main cache: whether it was admitted and, when the main cache was
+// full, which victim it was compared with and both frequency estimates. It is
+// meant for debugging low hit rates, it locks the main cache.
+func (c *Cache[K, V]) Explain(key K) Explanation[K] {
+	return Explanation[K](c.store.Explain(key))
+}
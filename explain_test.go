@@ -0,0 +1,99 @@
+package wtlfu_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"wtlfu"
+)
+
+func TestCache_SetWithOptions(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	require.Equal(t, wtlfu.SetRejected, cache.SetWithOptions("foo", 1, wtlfu.SetOptions{}))
+	_, ok := cache.Get("foo")
+	require.False(t, ok)
+	require.True(t, cache.Explain("foo").DoorkeeperSeen)
+
+	require.Equal(t, wtlfu.SetAdmitted, cache.SetWithOptions("foo", 1, wtlfu.SetOptions{}))
+	require.Equal(t, wtlfu.StageWindow, cache.Explain("foo").Stage)
+	require.Equal(t, wtlfu.SetUpdated, cache.SetWithOptions("foo", 2, wtlfu.SetOptions{TTL: time.Minute}))
+	v, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+}
+
+func TestCache_SetWithOptionsCandidate(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		ShardCount(1).
+		Weigher(100, func(_ string, v int) uint32 { return uint32(v) }).
+		Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	cache.Set("heavy", 50)
+	require.Equal(t, wtlfu.SetCandidate, cache.SetWithOptions("heavy", 50, wtlfu.SetOptions{}))
+	require.Eventually(t, func() bool {
+		e := cache.Explain("heavy")
+		return e.HasDuel && e.Admitted && !e.HasVictim && e.Stage == wtlfu.StageProbation
+	}, time.Second, time.Millisecond)
+}
+
+func TestCache_ExplainDuel(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](1000).ShardCount(1).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	// writes do not count in the sketch, the main cache is full of keys never read
+	for i := 0; i < 1000; i++ {
+		set(cache, "fill"+strconv.Itoa(i), i)
+	}
+	// a read buffer is drained into the sketch every 64 reads
+	for i := 0; i < 64; i++ {
+		cache.Get("warm")
+	}
+	set(cache, "cold", 0)
+	set(cache, "warm", 0)
+	// push cold and warm out of the window so they have to beat a victim
+	for i := 0; i < 100; i++ {
+		set(cache, "next"+strconv.Itoa(i), i)
+	}
+
+	var cold, warm wtlfu.Explanation[string]
+	require.Eventually(t, func() bool {
+		cold, warm = cache.Explain("cold"), cache.Explain("warm")
+		return cold.HasDuel && warm.HasDuel
+	}, time.Second, time.Millisecond)
+
+	require.Equal(t, wtlfu.StageAbsent, cold.Stage)
+	require.False(t, cold.Admitted)
+	require.True(t, cold.HasVictim)
+	require.Contains(t, cold.Victim, "fill")
+	require.Equal(t, int64(0), cold.DuelFrequency)
+	require.Equal(t, int64(0), cold.VictimFrequency)
+
+	require.Equal(t, wtlfu.StageProbation, warm.Stage)
+	require.True(t, warm.Admitted)
+	require.False(t, warm.Random)
+	require.True(t, warm.HasVictim)
+	require.Greater(t, warm.DuelFrequency, warm.VictimFrequency)
+	require.Equal(t, wtlfu.StageAbsent, cache.Explain(warm.Victim).Stage)
+}
+
+func TestCache_SetWithOptionsForce(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	require.Equal(t, wtlfu.SetAdmitted, cache.SetWithOptions("foo", 1, wtlfu.SetOptions{Force: true}))
+	v, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	require.True(t, cache.Explain("foo").DoorkeeperSeen)
+}
@@ -0,0 +1,55 @@
+package wtlfu
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+
+	"wtlfu/internal"
+)
+
+// Description is the configuration, counters and shard sizes of a cache, see
+// Cache.Describe. The counters are zero unless stats are recorded.
+type Description = internal.Description
+
+// Describer is implemented by Cache and LoadingCache.
+type Describer interface {
+	Describe() Description
+}
+
+// Describe returns the configuration, counters and shard sizes of the cache.
+func (c *Cache[K, V]) Describe() Description {
+	return c.store.Describe()
+}
+
+var (
+	expvarMu     sync.Mutex
+	expvarCaches *expvar.Map
+)
+
+// PublishExpvar publishes the Description of cache under name in the "wtlfu"
+// expvar map, so it is served by /debug/vars. The description is computed on
+// each read of the variable. Nothing is published before the first call.
+func PublishExpvar(name string, cache Describer) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvarCaches == nil {
+		expvarCaches = expvar.NewMap("wtlfu")
+	}
+	if expvarCaches.Get(name) != nil {
+		return fmt.Errorf("wtlfu: expvar name %q already published", name)
+	}
+	expvarCaches.Set(name, expvar.Func(func() any {
+		return cache.Describe()
+	}))
+	return nil
+}
+
+// UnpublishExpvar removes the cache published under name, if any.
+func UnpublishExpvar(name string) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvarCaches != nil {
+		expvarCaches.Delete(name)
+	}
+}
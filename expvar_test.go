@@ -0,0 +1,56 @@
+package wtlfu_test
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"wtlfu"
+)
+
+func TestCache_Describe(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](1000).ShardCount(4).RecordStats().Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	set(cache, "foo", 1)
+	cache.Get("foo")
+	cache.Get("bar")
+
+	d := cache.Describe()
+	require.Equal(t, 1000, d.Capacity)
+	require.Equal(t, 1000, d.MaxWeight)
+	require.Equal(t, 4, d.ShardCount)
+	require.Equal(t, 1000, d.WindowCapacity+d.MainCapacity)
+	require.Equal(t, uint64(1), d.Hits)
+	require.Equal(t, uint64(1), d.Misses)
+	require.Equal(t, uint64(0), d.Removals["evicted"])
+	require.Len(t, d.ShardLens, 4)
+	total := 0
+	for _, n := range d.ShardLens {
+		total += n
+	}
+	require.Equal(t, 1, total)
+}
+
+func TestPublishExpvar(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).RecordStats().Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	require.Nil(t, wtlfu.PublishExpvar("expvar-test", cache))
+	defer wtlfu.UnpublishExpvar("expvar-test")
+	require.NotNil(t, wtlfu.PublishExpvar("expvar-test", cache))
+	cache.Get("foo")
+
+	var vars map[string]wtlfu.Description
+	require.Nil(t, json.Unmarshal([]byte(expvar.Get("wtlfu").String()), &vars))
+	require.Equal(t, uint64(1), vars["expvar-test"].Misses)
+	require.Equal(t, 100, vars["expvar-test"].Capacity)
+
+	wtlfu.UnpublishExpvar("expvar-test")
+	require.Nil(t, wtlfu.PublishExpvar("expvar-test", cache))
+}
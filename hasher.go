@@ -0,0 +1,36 @@
+package wtlfu
+
+import "wtlfu/internal"
+
+// Hasher hashes the keys of a cache, see Builder.Hasher. Equal keys must have
+// equal hashes, and the hashes should spread evenly over the 64 bits.
+type Hasher[K comparable] interface {
+	Hash(key K) uint64
+}
+
+// Hashable is implemented by keys that hash themselves, equal keys must have
+// equal hashes. The default hasher uses it before anything else.
+type Hashable = internal.Hashable
+
+// Hasher sets the hasher of the keys. Its hashes are mixed with a random seed
+// of the cache, so colliding keys of one cache do not collide in another.
+//
+// The default hasher handles strings and types based on strings, integers,
+// floats, bools, pointers, keys implementing Hashable, and arrays and structs
+// made of integers, bools and pointers without padding. Build refuses other
+// key types, such as interfaces or structs holding a string, whose equal
+// values may differ in memory, unless a Hasher is set.
+func (b *Builder[K, V]) Hasher(hasher Hasher[K]) *Builder[K, V] {
+	b.cfg.Hasher = hasher
+	return b
+}
+
+// Seed makes the cache deterministic for tests: the seeds of the key hashes,
+// of the frequency sketch and of the random admission are derived from seed
+// instead of being random. A predictable seed lets whoever knows it compute
+// colliding keys, production caches should keep the default 0, which picks a
+// random seed for every cache.
+func (b *Builder[K, V]) Seed(seed uint64) *Builder[K, V] {
+	b.cfg.Seed = seed
+	return b
+}
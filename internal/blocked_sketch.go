@@ -0,0 +1,118 @@
+package internal
+
+import "math/rand"
+
+const (
+	// blockWords is the number of uint64 in a block, 64 bytes, the size of a cache line
+	blockWords = 8
+	// resetMask keeps the 3 low bits of every 4-bit counter once a word is shifted right
+	resetMask = 0x7777777777777777
+)
+
+// frequencySketch estimates how often a hash was incremented, up to 15
+type frequencySketch interface {
+	increment(hashed uint64)
+	estimate(hashed uint64) int64
+	// reset halves every counter
+	reset()
+}
+
+// newFrequencySketch returns a sketch of kind sized for n keys, with conservative update
+// an increment only bumps the counters equal to the current estimate, so a key sharing
+// counters with hotter keys is less overestimated
+func newFrequencySketch(kind SketchKind, n int64, conservative bool, seeds *rand.Rand) frequencySketch {
+	if kind == SketchBlocked {
+		s := newBlockedSketch(n, seeds)
+		s.conservative = conservative
+		return s
+	}
+	s := newCmSketch(n, seeds)
+	s.conservative = conservative
+	return s
+}
+
+// blockedSketch is a count-min sketch keeping the cmDepth counters of a hash in one
+// 64-byte block, so an increment or an estimate touches a single cache line instead of
+// one per row. Each depth picks a counter in its own pair of words of the block, as the
+// FrequencySketch of Caffeine does. It holds as many counters as a cmSketch of the same size
+type blockedSketch struct {
+	table []uint64
+	// blockMask masks the index of a block, there are at most 2^32 blocks
+	blockMask uint64
+	seed      uint64
+	// conservative only increments the counters equal to the estimate
+	conservative bool
+}
+
+func newBlockedSketch(n int64, seeds *rand.Rand) *blockedSketch {
+	if n == 0 {
+		panic("blockedSketch: bad numCounters")
+	}
+	// cmDepth rows of numCounters 4-bit counters, 16 counters per word
+	words := next2Power(n) * cmDepth / 16
+	if words < blockWords {
+		words = blockWords
+	}
+	return &blockedSketch{
+		table:     make([]uint64, words),
+		blockMask: uint64(words/blockWords - 1),
+		seed:      seeds.Uint64(),
+	}
+}
+
+// mix is the finalizer of murmur3, every bit of x changes half the bits of the result
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// slot returns the word and the bit offset of the counter of depth i, the block is
+// chosen by the low bits of the mixed hash and the counters by its high 32 bits, a
+// byte per depth
+func (s *blockedSketch) slot(mixed uint64, i int) (int, uint64) {
+	block := (mixed & s.blockMask) * blockWords
+	h := mixed >> (32 + i*8)
+	// bit 0 picks a word of the pair of depth i, bits 1-4 the counter in the word
+	word := block + h&1 + uint64(i)*2
+	return int(word), ((h >> 1) & 15) * 4
+}
+
+func (s *blockedSketch) increment(hashed uint64) {
+	mixed := mix(hashed ^ s.seed)
+	// only counters below limit are incremented, with conservative update that is the
+	// counters equal to the estimate, which is at most 14 here
+	limit := uint64(15)
+	if s.conservative {
+		if limit = uint64(s.estimate(hashed)) + 1; limit > 15 {
+			return
+		}
+	}
+	for i := 0; i < cmDepth; i++ {
+		word, shift := s.slot(mixed, i)
+		if (s.table[word]>>shift)&0x0f < limit {
+			s.table[word] += 1 << shift
+		}
+	}
+}
+
+func (s *blockedSketch) estimate(hashed uint64) int64 {
+	mixed := mix(hashed ^ s.seed)
+	min := uint64(15)
+	for i := 0; i < cmDepth; i++ {
+		word, shift := s.slot(mixed, i)
+		if v := (s.table[word] >> shift) & 0x0f; v < min {
+			min = v
+		}
+	}
+	return int64(min)
+}
+
+func (s *blockedSketch) reset() {
+	for i := range s.table {
+		s.table[i] = (s.table[i] >> 1) & resetMask
+	}
+}
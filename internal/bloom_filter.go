@@ -2,6 +2,7 @@ package internal
 
 import (
 	"math"
+	"math/bits"
 )
 
 // bloomFilter is a small bloom-filter-based cache admission policy
@@ -42,6 +43,18 @@ func (d *bloomFilter) insert(h uint64) bool {
 	return o == 1
 }
 
+// contains reports whether h is considered to be in the bloom filter, without inserting it
+func (d *bloomFilter) contains(h uint64) bool {
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := uint32(0); i < d.k; i++ {
+		bit := (h1 + (i * h2)) & (d.m - 1)
+		if d.filter[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // Reset clears the bloom filter
 func (d *bloomFilter) reset() {
 	if d == nil {
@@ -52,6 +65,18 @@ func (d *bloomFilter) reset() {
 	}
 }
 
+// fillRatio returns the share of bits set, the false positive rate grows with it
+func (d *bloomFilter) fillRatio() float64 {
+	if d == nil {
+		return 0
+	}
+	set := 0
+	for _, w := range d.filter {
+		set += bits.OnesCount64(w)
+	}
+	return float64(set) / float64(d.m)
+}
+
 // Internal routines for the bit vector
 type bitvector []uint64
 
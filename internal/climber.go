@@ -0,0 +1,68 @@
+package internal
+
+import "math"
+
+const (
+	// climberSampleFactor sets the sample size to this many times the expected number of entries
+	climberSampleFactor = 10
+	// climberStepPercent is the share of the max weight moved by a restarted climb
+	climberStepPercent = 0.0625
+	// climberStepDecay shrinks the step after every sample so the split settles
+	climberStepDecay = 0.98
+	// climberRestartThreshold is the hit rate change that restarts the climb with a full step
+	climberRestartThreshold = 0.05
+	// maxWindowRatio bounds the window so the main cache keeps most of the weight
+	maxWindowRatio = 0.8
+)
+
+// hillClimber tunes the window/main split of the adaptive W-TinyLFU: it samples
+// the hit rate and keeps moving weight in the same direction while the hit rate
+// improves, reversing otherwise, with a step that decays over time
+type hillClimber struct {
+	hits, misses int
+	sampleSize   int
+	maxWeight    int
+	prevHitRate  float64
+	stepSize     float64
+}
+
+func newHillClimber(capacity, maxWeight int) *hillClimber {
+	return &hillClimber{
+		sampleSize: climberSampleFactor * capacity,
+		maxWeight:  maxWeight,
+		// the window starts at its smallest useful size, so the first step grows it
+		stepSize: climberStepPercent * float64(maxWeight),
+	}
+}
+
+// record counts a read in the current sample
+func (c *hillClimber) record(hit bool) {
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// adjust returns the weight to move to the window, negative to move it to the main
+// cache, once the sample is complete. It returns 0 while the sample is not complete
+func (c *hillClimber) adjust() int {
+	sampleCount := c.hits + c.misses
+	if sampleCount < c.sampleSize {
+		return 0
+	}
+	hitRate := float64(c.hits) / float64(sampleCount)
+	hitRateChange := hitRate - c.prevHitRate
+	amount := c.stepSize
+	if hitRateChange < 0 {
+		amount = -amount
+	}
+	if math.Abs(hitRateChange) >= climberRestartThreshold {
+		c.stepSize = math.Copysign(climberStepPercent*float64(c.maxWeight), amount)
+	} else {
+		c.stepSize = climberStepDecay * amount
+	}
+	c.prevHitRate = hitRate
+	c.hits, c.misses = 0, 0
+	return int(amount)
+}
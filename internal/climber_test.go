@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sample(c *hillClimber, hits, misses int) int {
+	for i := 0; i < hits; i++ {
+		c.record(true)
+	}
+	for i := 0; i < misses; i++ {
+		c.record(false)
+	}
+	return c.adjust()
+}
+
+func TestHillClimber_Adjust(t *testing.T) {
+	c := newHillClimber(100, 1600)
+	step := int(climberStepPercent * 1600)
+
+	// nothing moves until the sample is complete
+	c.record(true)
+	assert.Equal(t, 0, c.adjust())
+
+	// the hit rate improved from nothing, the window grows
+	assert.Equal(t, step, sample(c, 499, 500))
+	// a small improvement keeps the direction, the step decays
+	assert.Equal(t, step, sample(c, 510, 490))
+	decayed := climberStepDecay * float64(step)
+	// a small loss reverses the direction
+	assert.Equal(t, -int(decayed), sample(c, 500, 500))
+	// shrinking lost more, reverse again, the large change restarts the next step
+	assert.Equal(t, int(climberStepDecay*decayed), sample(c, 400, 600))
+	assert.Equal(t, step, sample(c, 800, 200))
+}
+
+func TestStore_ResizeWindow(t *testing.T) {
+	s, err := NewStoreWithConfig(Config[int, int]{Capacity: 1000, ShardCount: 2})
+	assert.Nil(t, err)
+	defer s.Close(context.Background())
+	for i := 0; i < 2000; i++ {
+		s.set(i, i, 0, true)
+	}
+
+	s.mu.Lock()
+	s.resizeWindow(400)
+	s.mu.Unlock()
+	assert.Equal(t, Split{Window: 410, Main: 590, Protected: 472}, s.Split())
+
+	s.mu.Lock()
+	s.resizeWindow(-1000)
+	s.mu.Unlock()
+	assert.Equal(t, Split{Window: 2, Main: 998, Protected: 799}, s.Split())
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		assert.LessOrEqual(t, shard.window.Weight(), 1)
+		shard.mu.RUnlock()
+	}
+
+	s.mu.Lock()
+	s.resizeWindow(2000)
+	s.mu.Unlock()
+	assert.Equal(t, 800, s.Split().Window)
+}
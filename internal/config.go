@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"time"
+)
+
+const (
+	DefaultWindowRatio      = 0.01
+	DefaultProbationRatio   = 0.2
+	DefaultDoorkeeperFactor = 20
+	DefaultDoorkeeperFPR    = 0.01
+	DefaultTickInterval     = 500 * time.Millisecond
+	// DefaultSampleFactor sets the default SampleSize to this many times the capacity
+	DefaultSampleFactor     = 10
+	MinShardCapacity        = 50
+	defaultWriteBuffDivisor = 100
+	maxShardNum             = 1 << 16 // Item.shardNum is an uint16
+)
+
+// DoorkeeperMode tells what the doorkeeper bloom filter is used for
+type DoorkeeperMode uint8
+
+const (
+	// DoorkeeperAdmission rejects the first write of every key not in the store, it is the default
+	DoorkeeperAdmission DoorkeeperMode = iota
+	// DoorkeeperFrequency admits every write, the doorkeeper only filters the sketch
+	// increments as in the TinyLFU paper: the first access of a key sets its doorkeeper
+	// bits, and only the next ones reach the sketch until the sketch ages
+	DoorkeeperFrequency
+	// DoorkeeperDisabled admits every write and counts every access in the sketch
+	DoorkeeperDisabled
+)
+
+func (m DoorkeeperMode) String() string {
+	switch m {
+	case DoorkeeperAdmission:
+		return "admission"
+	case DoorkeeperFrequency:
+		return "frequency"
+	case DoorkeeperDisabled:
+		return "disabled"
+	}
+	return "unknown"
+}
+
+// SketchKind is the layout of the count-min sketch estimating the frequencies
+type SketchKind uint8
+
+const (
+	// SketchRows keeps each depth of the sketch in its own row, it is the default
+	SketchRows SketchKind = iota
+	// SketchBlocked keeps the counters of a key in one cache line
+	SketchBlocked
+)
+
+func (k SketchKind) String() string {
+	switch k {
+	case SketchRows:
+		return "rows"
+	case SketchBlocked:
+		return "blocked"
+	}
+	return "unknown"
+}
+
+// ConfigError reports an invalid Config field
+type ConfigError struct {
+	Field  string
+	Value  any
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("wtlfu: invalid %s %v: %s", e.Field, e.Value, e.Reason)
+}
+
+// Config holds the options used to build a Store, zero values are replaced by defaults
+type Config[K comparable, V any] struct {
+	// Capacity is the max number of entries the store holds. With a Weigher it only
+	// sizes the internal structures, the expected number of entries, and MaxWeight bounds the store
+	Capacity int
+	// WindowRatio is the share of Capacity used by the window lru, default 1%
+	WindowRatio float64
+	// ProbationRatio is the share of the main slru used by the probation segment, default 20%
+	ProbationRatio float64
+	// ShardCount is the number of shards, it must be a power of two.
+	// Default is the next power of two above runtime.NumCPU(), halved while a shard
+	// would hold less than MinShardCapacity entries
+	ShardCount int
+	// DoorkeeperFactor sizes each shard doorkeeper as DoorkeeperFactor * shard capacity, default 20
+	DoorkeeperFactor int
+	// DoorkeeperFPR is the false positive rate of the doorkeeper bloom filter, default 1%
+	DoorkeeperFPR float64
+	// DoorkeeperMode tells what the doorkeeper is used for, default DoorkeeperAdmission
+	DoorkeeperMode DoorkeeperMode
+	// SampleSize is the number of accesses after which the sketch counters are halved,
+	// and the doorkeepers cleared, so old frequencies fade. Default 10 * Capacity
+	SampleSize int
+	// Sketch is the layout of the frequency sketch, default SketchRows
+	Sketch SketchKind
+	// Hasher hashes the keys, default is the HashKey of K, which refuses keys it can not hash
+	Hasher Hasher[K]
+	// Clock tells the time used by expiration, default SystemClock
+	Clock Clock
+	// Seed seeds the hashes, the sketch and the random admission, so a store behaves the
+	// same in every run. The default 0 picks a random seed for every store
+	Seed uint64
+	// ConservativeUpdate only increments the sketch counters of a key equal to its estimate
+	ConservativeUpdate bool
+	// WriteBufferSize is the size of the write buffer, default Capacity/100 clamped to [4, 1024]
+	WriteBufferSize int
+	// TickInterval is how often the timer wheel is advanced, default 500ms
+	TickInterval time.Duration
+	// ExpireAfterWrite is the ttl of entries written without one, 0 means they never expire
+	ExpireAfterWrite time.Duration
+	// ExpireAfterAccess expires entries not read or written for this long, 0 disables it
+	ExpireAfterAccess time.Duration
+	// Expiry computes the ttl of entries written without one and extends it on read,
+	// it can not be combined with ExpireAfterWrite
+	Expiry Expiry[K, V]
+	// RemovalListener is called whenever an entry leaves the store
+	RemovalListener func(key K, value V, reason RemoveReason)
+	// NotifyOnClose calls the RemovalListener with CLOSED for every entry left when the store is closed
+	NotifyOnClose bool
+	// AdaptiveWindow lets a hill climber move weight between the window and the main cache
+	// at runtime to follow the hit rate, WindowRatio is then only the initial split
+	AdaptiveWindow bool
+	// StatsCounter records the events of the store, nil disables stats
+	StatsCounter StatsCounter
+	// Weigher returns the weight of an entry, every entry weighs 1 without it
+	Weigher func(key K, value V) uint32
+	// MaxWeight is the max total weight of the entries, it is required with a Weigher
+	MaxWeight int64
+}
+
+// withDefaults returns a copy of c whose zero fields are replaced by defaults
+func (c Config[K, V]) withDefaults() Config[K, V] {
+	if c.WindowRatio == 0 {
+		c.WindowRatio = DefaultWindowRatio
+	}
+	if c.ProbationRatio == 0 {
+		c.ProbationRatio = DefaultProbationRatio
+	}
+	if c.ShardCount == 0 {
+		shardNum := 1
+		for shardNum < runtime.NumCPU() {
+			shardNum *= 2
+		}
+		for shardNum > 1 && c.Capacity/shardNum < MinShardCapacity {
+			shardNum /= 2
+		}
+		c.ShardCount = shardNum
+	}
+	if c.DoorkeeperFactor == 0 {
+		c.DoorkeeperFactor = DefaultDoorkeeperFactor
+	}
+	if c.DoorkeeperFPR == 0 {
+		c.DoorkeeperFPR = DefaultDoorkeeperFPR
+	}
+	if c.SampleSize == 0 {
+		c.SampleSize = DefaultSampleFactor * c.Capacity
+	}
+	if c.Clock == nil {
+		c.Clock = SystemClock{}
+	}
+	if c.WriteBufferSize == 0 {
+		size := c.Capacity / defaultWriteBuffDivisor
+		if size < MinWriteBuffSize {
+			size = MinWriteBuffSize
+		}
+		if size > MaxWriteBuffSize {
+			size = MaxWriteBuffSize
+		}
+		c.WriteBufferSize = size
+	}
+	if c.TickInterval == 0 {
+		c.TickInterval = DefaultTickInterval
+	}
+	return c
+}
+
+// maxWeight returns the max total weight of the store, which is Capacity without a Weigher
+func (c Config[K, V]) maxWeight() int {
+	if c.Weigher == nil {
+		return c.Capacity
+	}
+	return int(c.MaxWeight)
+}
+
+// windowCap returns the window weight of each shard, every shard has at least one window slot
+func (c Config[K, V]) windowCap() int {
+	total := int(math.Round(float64(c.maxWeight()) * c.WindowRatio))
+	windowCap := (total + c.ShardCount - 1) / c.ShardCount
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	return windowCap
+}
+
+// shardCap returns the capacity of each shard, rounded up
+func (c Config[K, V]) shardCap() int {
+	return (c.Capacity + c.ShardCount - 1) / c.ShardCount
+}
+
+// mainCap returns the weight of the main slru
+func (c Config[K, V]) mainCap() int {
+	return c.maxWeight() - c.windowCap()*c.ShardCount
+}
+
+// Validate checks a Config whose defaults have been applied
+func (c Config[K, V]) Validate() error {
+	if c.Capacity <= 0 {
+		return &ConfigError{"Capacity", c.Capacity, "must be positive"}
+	}
+	if c.WindowRatio <= 0 || c.WindowRatio >= 1 {
+		return &ConfigError{"WindowRatio", c.WindowRatio, "must be in (0, 1)"}
+	}
+	if c.ProbationRatio <= 0 || c.ProbationRatio >= 1 {
+		return &ConfigError{"ProbationRatio", c.ProbationRatio, "must be in (0, 1)"}
+	}
+	if c.ShardCount <= 0 || c.ShardCount&(c.ShardCount-1) != 0 {
+		return &ConfigError{"ShardCount", c.ShardCount, "must be a positive power of two"}
+	}
+	if c.ShardCount > maxShardNum {
+		return &ConfigError{"ShardCount", c.ShardCount, fmt.Sprintf("must not exceed %d", maxShardNum)}
+	}
+	if c.MaxWeight < 0 {
+		return &ConfigError{"MaxWeight", c.MaxWeight, "must be positive"}
+	}
+	if c.Weigher != nil && c.MaxWeight == 0 {
+		return &ConfigError{"MaxWeight", c.MaxWeight, "is required with a Weigher"}
+	}
+	if c.Weigher == nil && c.MaxWeight != 0 {
+		return &ConfigError{"MaxWeight", c.MaxWeight, "needs a Weigher"}
+	}
+	if c.mainCap() < 1 && c.Weigher != nil {
+		return &ConfigError{"MaxWeight", c.MaxWeight,
+			fmt.Sprintf("too small for %d shards with window ratio %v, main cache would be empty", c.ShardCount, c.WindowRatio)}
+	}
+	if c.mainCap() < 1 {
+		return &ConfigError{"Capacity", c.Capacity,
+			fmt.Sprintf("too small for %d shards with window ratio %v, main cache would be empty", c.ShardCount, c.WindowRatio)}
+	}
+	if c.DoorkeeperFactor < 0 {
+		return &ConfigError{"DoorkeeperFactor", c.DoorkeeperFactor, "must be positive"}
+	}
+	if c.DoorkeeperFPR <= 0 || c.DoorkeeperFPR >= 1 {
+		return &ConfigError{"DoorkeeperFPR", c.DoorkeeperFPR, "must be in (0, 1)"}
+	}
+	if c.DoorkeeperMode > DoorkeeperDisabled {
+		return &ConfigError{"DoorkeeperMode", c.DoorkeeperMode, "is unknown"}
+	}
+	if c.SampleSize < 0 {
+		return &ConfigError{"SampleSize", c.SampleSize, "must be positive"}
+	}
+	if c.Sketch > SketchBlocked {
+		return &ConfigError{"Sketch", c.Sketch, "is unknown"}
+	}
+	if c.Hasher == nil {
+		if _, err := NewHash[K](0); err != nil {
+			return &ConfigError{"Hasher", nil, err.Error()}
+		}
+	}
+	if c.WriteBufferSize < 0 {
+		return &ConfigError{"WriteBufferSize", c.WriteBufferSize, "must be positive"}
+	}
+	if c.TickInterval < 0 {
+		return &ConfigError{"TickInterval", c.TickInterval, "must be positive"}
+	}
+	if c.ExpireAfterWrite < 0 {
+		return &ConfigError{"ExpireAfterWrite", c.ExpireAfterWrite, "must be positive"}
+	}
+	if c.ExpireAfterAccess < 0 {
+		return &ConfigError{"ExpireAfterAccess", c.ExpireAfterAccess, "must be positive"}
+	}
+	if c.Expiry != nil && c.ExpireAfterWrite != 0 {
+		return &ConfigError{"Expiry", c.Expiry, "can not be combined with ExpireAfterWrite"}
+	}
+	return nil
+}
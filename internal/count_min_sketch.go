@@ -1,9 +1,6 @@
 package internal
 
-import (
-	"math/rand"
-	"time"
-)
+import "math/rand"
 
 const cmDepth = 4
 
@@ -11,6 +8,8 @@ type cmSketch struct {
 	rows [cmDepth]cmRow
 	seed [cmDepth]uint64
 	mask uint64
+	// conservative only increments the counters equal to the estimate
+	conservative bool
 }
 
 //numCounter - 1 = next2Power(n) = 0111111(n个1）
@@ -20,7 +19,7 @@ type cmSketch struct {
 //0000,0000|0000,0000|0000,0000
 //0000,0000|0000,0000|0000,0000
 
-func newCmSketch(n int64) *cmSketch {
+func newCmSketch(n int64, seeds *rand.Rand) *cmSketch {
 	if n == 0 {
 		panic("cmSketch: bad numCounters")
 	}
@@ -28,17 +27,37 @@ func newCmSketch(n int64) *cmSketch {
 	numCounters := next2Power(n)
 	sketch := &cmSketch{mask: uint64(numCounters - 1)}
 	// Initialize rows of counters and seeds.
-	source := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for i := 0; i < cmDepth; i++ {
-		sketch.seed[i] = source.Uint64()
+		// odd seeds are multipliers that keep every bit of the hash
+		sketch.seed[i] = seeds.Uint64() | 1
 		sketch.rows[i] = newCmRow(numCounters)
 	}
 	return sketch
 }
 
+// index returns the counter of hashed in row i. Masking hashed ^ seed would give every
+// row the same collisions, the multiplication moves the high bits of the hash down so
+// each row has its own
+func (s *cmSketch) index(hashed uint64, i int) uint64 {
+	h := (hashed + s.seed[i]) * s.seed[i]
+	h += h >> 32
+	return h & s.mask
+}
+
 func (s *cmSketch) increment(hashed uint64) {
+	if s.conservative {
+		// 只增加等于最小值的计数器，减少冷key被热key抬高的估计
+		min := byte(s.estimate(hashed))
+		for i := range s.rows {
+			n := s.index(hashed, i)
+			if s.rows[i].get(n) == min {
+				s.rows[i].increment(n)
+			}
+		}
+		return
+	}
 	for i := range s.rows {
-		s.rows[i].increment((hashed ^ s.seed[i]) & s.mask)
+		s.rows[i].increment(s.index(hashed, i))
 	}
 }
 
@@ -46,7 +65,7 @@ func (s *cmSketch) increment(hashed uint64) {
 func (s *cmSketch) estimate(hashed uint64) int64 {
 	min := byte(255)
 	for i := range s.rows {
-		val := s.rows[i].get((hashed ^ s.seed[i]) & s.mask)
+		val := s.rows[i].get(s.index(hashed, i))
 		if val < min {
 			min = val
 		}
@@ -0,0 +1,92 @@
+package internal
+
+// ShardDebug is the state of one shard in a DebugSnapshot
+type ShardDebug[K comparable] struct {
+	Len       int
+	WindowLen int
+	// WindowKeys are the first keys of the window, most recent first
+	WindowKeys []K
+	// DoorkeeperFill is the share of the doorkeeper bits set, 0 unless the doorkeeper mode is admission
+	DoorkeeperFill float64
+}
+
+// DebugSnapshot is the internal state of a store, see Store.Debug
+type DebugSnapshot[K comparable] struct {
+	Shards       []ShardDebug[K]
+	ProbationLen int
+	ProtectedLen int
+	// ProbationKeys and ProtectedKeys are the first keys of each segment, most recent first
+	ProbationKeys []K
+	ProtectedKeys []K
+	// Frequency is the sketch estimate of the queried key, if any
+	Frequency int64
+	// TimerWheel is the number of items in each bucket of each level
+	TimerWheel [][]int
+	// ReadBuffer is the number of reads recorded since the last drain, WriteBuffer the pending writes
+	ReadBuffer  int
+	WriteBuffer int
+	// DoorkeeperMode tells which doorkeeper is in use: the one of each shard with admission,
+	// the one of the policy, whose fill is PolicyDoorkeeperFill, with frequency
+	DoorkeeperMode       DoorkeeperMode
+	PolicyDoorkeeperFill float64
+}
+
+// Debug returns a snapshot of the internals of the store with at most topN keys per segment,
+// and the sketch estimate of key when hasKey is set. Each shard lock is held only while its
+// window is copied, the store lock while the main cache and the timer wheel are copied
+func (s *Store[K, V]) Debug(topN int, key K, hasKey bool) DebugSnapshot[K] {
+	d := DebugSnapshot[K]{
+		Shards:      make([]ShardDebug[K], len(s.shards)),
+		ReadBuffer:  int(s.readCounter.Load()),
+		WriteBuffer: len(s.writeBuf),
+	}
+	for i, shard := range s.shards {
+		shard.mu.RLock()
+		d.Shards[i] = ShardDebug[K]{
+			Len:            len(shard.dict),
+			WindowLen:      shard.window.Len(),
+			WindowKeys:     topKeys(shard.window.list, topN),
+			DoorkeeperFill: shard.doorkeeper.fillRatio(),
+		}
+		shard.mu.RUnlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.policy.doorkeeper != nil:
+		d.DoorkeeperMode = DoorkeeperFrequency
+		d.PolicyDoorkeeperFill = s.policy.doorkeeper.fillRatio()
+	case s.shards[0].doorkeeper != nil:
+		d.DoorkeeperMode = DoorkeeperAdmission
+	default:
+		d.DoorkeeperMode = DoorkeeperDisabled
+	}
+	d.ProbationLen = s.policy.mainCache.firstSegment.Len()
+	d.ProtectedLen = s.policy.mainCache.secondSegment.Len()
+	d.ProbationKeys = topKeys(s.policy.mainCache.firstSegment, topN)
+	d.ProtectedKeys = topKeys(s.policy.mainCache.secondSegment, topN)
+	if hasKey {
+		d.Frequency = s.policy.estimate(s.hash.Hash(key))
+	}
+	d.TimerWheel = make([][]int, len(s.timerWheel.wheel))
+	for i, level := range s.timerWheel.wheel {
+		d.TimerWheel[i] = make([]int, len(level))
+		for j, list := range level {
+			d.TimerWheel[i][j] = list.Len()
+		}
+	}
+	return d
+}
+
+// topKeys returns the keys of the first n items of l
+func topKeys[K comparable, V any](l *List[K, V], n int) []K {
+	if n > l.Len() {
+		n = l.Len()
+	}
+	keys := make([]K, 0, n)
+	for item := l.Front(); item != nil && len(keys) < n; item = item.Next(l.listType) {
+		keys = append(keys, item.key)
+	}
+	return keys
+}
@@ -0,0 +1,35 @@
+package internal
+
+import "time"
+
+// Expiry computes the ttl of each entry, a returned duration <= 0 means the entry never expires.
+// currentDuration is the remaining ttl of the entry, 0 if it does not expire, so returning it keeps the expiration.
+// The hooks are called with the shard lock held, they must not use the store
+type Expiry[K comparable, V any] interface {
+	// ExpireAfterCreate returns the ttl of an entry written for the first time
+	ExpireAfterCreate(key K, value V) time.Duration
+	// ExpireAfterUpdate returns the ttl of an entry whose value was replaced
+	ExpireAfterUpdate(key K, value V, currentDuration time.Duration) time.Duration
+	// ExpireAfterRead returns the ttl of an entry after it was read
+	ExpireAfterRead(key K, value V, currentDuration time.Duration) time.Duration
+}
+
+// expireAt converts a ttl to an absolute expiration in nanoseconds, 0 means never
+func expireAt(now int64, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return now + ttl.Nanoseconds()
+}
+
+// remaining returns the ttl left before expire, 0 if the item never expires
+func remaining(expire, now int64) time.Duration {
+	if expire == 0 {
+		return 0
+	}
+	if expire <= now {
+		// an expired entry keeps a tiny positive ttl, 0 would mean it never expires
+		return 1
+	}
+	return time.Duration(expire - now)
+}
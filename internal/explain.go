@@ -0,0 +1,168 @@
+package internal
+
+// SetResult tells what happened to a write
+type SetResult uint8
+
+const (
+	// SetRejected means the doorkeeper had not seen the key before, the value was not stored
+	SetRejected SetResult = iota
+	// SetAdmitted means a new entry was stored in the window
+	SetAdmitted
+	// SetUpdated means the value of an existing entry was replaced
+	SetUpdated
+	// SetCandidate means a new entry heavier than the window was stored and sent
+	// straight to the main cache admission, which may still reject it
+	SetCandidate
+	// SetClosed means the store is closed, the value was not stored
+	SetClosed
+)
+
+func (r SetResult) String() string {
+	switch r {
+	case SetRejected:
+		return "rejected by doorkeeper"
+	case SetAdmitted:
+		return "admitted to window"
+	case SetUpdated:
+		return "updated"
+	case SetCandidate:
+		return "candidate for main cache"
+	case SetClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Stage is where an entry is in the policy
+type Stage uint8
+
+const (
+	// StageAbsent means the key is not in the store
+	StageAbsent Stage = iota
+	// StageWindow means the entry is in the window of its shard
+	StageWindow
+	// StageCandidate means the entry left the window and waits for the main cache admission
+	StageCandidate
+	// StageProbation and StageProtected are the segments of the main cache
+	StageProbation
+	StageProtected
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageAbsent:
+		return "absent"
+	case StageWindow:
+		return "window"
+	case StageCandidate:
+		return "candidate"
+	case StageProbation:
+		return "probation"
+	case StageProtected:
+		return "protected"
+	}
+	return "unknown"
+}
+
+// duelLogSize is the number of admission duels remembered for Explain
+const duelLogSize = 1024
+
+// duel is an admission decision of the main cache
+type duel[K comparable] struct {
+	key      K
+	admitted bool
+	// random reports that the key was admitted by chance without beating the victim
+	random    bool
+	hasVictim bool
+	victim    K
+	// freq and victimFreq are the sketch estimates compared by the duel
+	freq       int64
+	victimFreq int64
+}
+
+// duelLog is a ring of the last admission decisions, guarded by the store lock
+type duelLog[K comparable] struct {
+	duels []duel[K]
+	next  int
+}
+
+func (l *duelLog[K]) add(d duel[K]) {
+	if l.duels == nil {
+		l.duels = make([]duel[K], 0, duelLogSize)
+	}
+	if len(l.duels) < duelLogSize {
+		l.duels = append(l.duels, d)
+	} else {
+		l.duels[l.next] = d
+	}
+	l.next = (l.next + 1) % duelLogSize
+}
+
+// last returns the most recent duel of key
+func (l *duelLog[K]) last(key K) (duel[K], bool) {
+	for i := 1; i <= len(l.duels); i++ {
+		d := l.duels[(l.next-i+duelLogSize)%duelLogSize]
+		if d.key == key {
+			return d, true
+		}
+	}
+	return duel[K]{}, false
+}
+
+// Explanation tells where a key is in the policy and why
+type Explanation[K comparable] struct {
+	Stage Stage
+	// Frequency is the sketch estimate of the key
+	Frequency int64
+	// DoorkeeperSeen reports whether the next first write of the key passes the doorkeeper,
+	// always true when the doorkeeper does not filter writes
+	DoorkeeperSeen bool
+	// HasDuel reports whether the last main cache admission of the key is still remembered,
+	// the fields below describe it. A duel without victim happens when the main cache has room
+	HasDuel  bool
+	Admitted bool
+	// Random reports that the key lost the duel but was admitted by chance, as warm keys sometimes are
+	Random          bool
+	HasVictim       bool
+	Victim          K
+	DuelFrequency   int64
+	VictimFrequency int64
+}
+
+// Explain returns where key is in the policy, and the last admission duel of the key if
+// it is among the last duelLogSize ones
+func (s *Store[K, V]) Explain(key K) Explanation[K] {
+	h, index := s.index(key)
+	shard := s.shards[index]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var e Explanation[K]
+	shard.mu.RLock()
+	if item, ok := shard.get(key); ok {
+		switch {
+		case item.inWindow:
+			e.Stage = StageWindow
+		case item.belong == ListProbation:
+			e.Stage = StageProbation
+		case item.belong == ListProtection:
+			e.Stage = StageProtected
+		default:
+			e.Stage = StageCandidate
+		}
+	}
+	e.DoorkeeperSeen = shard.doorkeeper == nil || shard.doorkeeper.contains(h)
+	shard.mu.RUnlock()
+
+	e.Frequency = s.policy.estimate(h)
+	if d, ok := s.policy.duels.last(key); ok {
+		e.HasDuel = true
+		e.Admitted = d.admitted
+		e.Random = d.random
+		e.HasVictim = d.hasVictim
+		e.Victim = d.victim
+		e.DuelFrequency = d.freq
+		e.VictimFrequency = d.victimFreq
+	}
+	return e
+}
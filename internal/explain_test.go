@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuelLog(t *testing.T) {
+	var l duelLog[int]
+	_, ok := l.last(1)
+	assert.False(t, ok)
+
+	l.add(duel[int]{key: 1, freq: 1})
+	l.add(duel[int]{key: 1, freq: 2})
+	d, ok := l.last(1)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), d.freq)
+
+	for i := 0; i < duelLogSize; i++ {
+		l.add(duel[int]{key: i + 2})
+	}
+	_, ok = l.last(1)
+	assert.False(t, ok, "the oldest duels are forgotten")
+	_, ok = l.last(duelLogSize + 1)
+	assert.True(t, ok)
+}
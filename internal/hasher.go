@@ -1,37 +1,195 @@
 package internal
 
 import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"reflect"
 	"unsafe"
+)
+
+// Hasher hashes keys, equal keys must have equal hashes
+type Hasher[K comparable] interface {
+	Hash(key K) uint64
+}
+
+// Hashable is implemented by keys that hash themselves, equal keys must have equal hashes
+type Hashable interface {
+	Hash() uint64
+}
+
+var hashableType = reflect.TypeOf((*Hashable)(nil)).Elem()
+
+// hashKind is how HashKey hashes a key type
+type hashKind uint8
 
-	"github.com/spaolacci/murmur3"
+const (
+	hashString hashKind = iota
+	// hashInt reads integers, bools and pointers of up to 8 bytes as an uint64
+	hashInt
+	hashFloat32
+	hashFloat64
+	// hashMemory hashes the bytes of keys made of plain data only, without padding
+	hashMemory
+	hashHashable
 )
 
+// HashKey is the default Hasher, it hashes keys with a seed of its own, so the hash
+// of a key can not be known outside of the process and colliding keys can not be
+// computed in advance. It never allocates
 type HashKey[K comparable] struct {
-	size  int
-	isStr bool
+	kind hashKind
+	size uintptr
+	seed uint64
 }
 
-func NewHash[K comparable]() *HashKey[K] {
-	h := &HashKey[K]{}
-	var k K
-	switch (any(k)).(type) {
-	case string:
-		h.isStr = true
+// NewHash returns the default Hasher of K seeded with seed, or an error when equal keys
+// of K would not always have equal hashes: strings, interfaces or floats inside a
+// composite key, or padding between its fields. Such keys must implement Hashable
+func NewHash[K comparable](seed uint64) (*HashKey[K], error) {
+	t := reflect.TypeOf((*K)(nil)).Elem()
+	h := &HashKey[K]{size: t.Size(), seed: seed}
+	switch kind := t.Kind(); {
+	case t.Implements(hashableType):
+		h.kind = hashHashable
+	case kind == reflect.String:
+		h.kind = hashString
+	case kind == reflect.Float32:
+		h.kind = hashFloat32
+	case kind == reflect.Float64:
+		h.kind = hashFloat64
+	case plainData(t) && h.size <= 8 && kind != reflect.Array && kind != reflect.Struct:
+		h.kind = hashInt
+	case plainData(t):
+		h.kind = hashMemory
 	default:
-		h.size = int(unsafe.Sizeof(k))
+		return nil, fmt.Errorf("key type %v can not be hashed by its memory, it must implement Hashable", t)
 	}
-	return h
+	return h, nil
+}
+
+// plainData reports whether the values of t are equal exactly when their memory is
+func plainData(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return true
+	case reflect.Array:
+		return plainData(t.Elem())
+	case reflect.Struct:
+		var size uintptr
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			// blank fields are ignored by ==
+			if f.Name == "_" || !plainData(f.Type) {
+				return false
+			}
+			size += f.Type.Size()
+		}
+		// padding bytes are ignored by == too
+		return size == t.Size()
+	}
+	// strings and interfaces hold pointers to their contents, +0 and -0 are equal floats
+	return false
 }
 
 func (h *HashKey[K]) Hash(key K) uint64 {
-	var strKey string
-	if h.isStr {
-		strKey = *(*string)(unsafe.Pointer(&key))
-	} else {
-		strKey = *(*string)(unsafe.Pointer(&struct {
-			data unsafe.Pointer
-			len  int
-		}{unsafe.Pointer(&key), h.size}))
-	}
-	return murmur3.Sum64([]byte(strKey))
+	p := unsafe.Pointer(&key)
+	switch h.kind {
+	case hashString:
+		s := *(*string)(p)
+		return murmur64(unsafe.Slice(unsafe.StringData(s), len(s)), h.seed)
+	case hashInt:
+		var v uint64
+		switch h.size {
+		case 1:
+			v = uint64(*(*uint8)(p))
+		case 2:
+			v = uint64(*(*uint16)(p))
+		case 4:
+			v = uint64(*(*uint32)(p))
+		default:
+			v = *(*uint64)(p)
+		}
+		return mix(v ^ h.seed)
+	case hashFloat32:
+		f := *(*float32)(p)
+		if f == 0 {
+			// -0 == +0
+			f = 0
+		}
+		return mix(uint64(math.Float32bits(f)) ^ h.seed)
+	case hashFloat64:
+		f := *(*float64)(p)
+		if f == 0 {
+			f = 0
+		}
+		return mix(math.Float64bits(f) ^ h.seed)
+	case hashHashable:
+		k, ok := any(key).(Hashable)
+		if !ok {
+			// K is an interface implementing Hashable and key is nil
+			return mix(h.seed)
+		}
+		return mix(k.Hash() ^ h.seed)
+	}
+	return murmur64(unsafe.Slice((*byte)(p), h.size), h.seed)
+}
+
+const (
+	murmurC1 = 0x87c37b91114253d5
+	murmurC2 = 0x4cf5ad432745937f
+)
+
+// murmur64 returns the first half of the 128-bit MurmurHash3 of b with a 64-bit seed,
+// for seeds below 2^32 it is the Sum64WithSeed of github.com/spaolacci/murmur3. Unlike
+// that one it does not let b escape, so hashing a string does not copy it to the heap
+func murmur64(b []byte, seed uint64) uint64 {
+	h1, h2 := seed, seed
+	n := len(b)
+	for ; len(b) >= 16; b = b[16:] {
+		k1 := binary.LittleEndian.Uint64(b)
+		k2 := binary.LittleEndian.Uint64(b[8:])
+		h1 ^= bits.RotateLeft64(k1*murmurC1, 31) * murmurC2
+		h1 = (bits.RotateLeft64(h1, 27)+h2)*5 + 0x52dce729
+		h2 ^= bits.RotateLeft64(k2*murmurC2, 33) * murmurC1
+		h2 = (bits.RotateLeft64(h2, 31)+h1)*5 + 0x38495ab5
+	}
+	var k1, k2 uint64
+	for i := len(b) - 1; i >= 8; i-- {
+		k2 = k2<<8 | uint64(b[i])
+	}
+	if len(b) > 8 {
+		h2 ^= bits.RotateLeft64(k2*murmurC2, 33) * murmurC1
+	}
+	low := len(b)
+	if low > 8 {
+		low = 8
+	}
+	for i := low - 1; i >= 0; i-- {
+		k1 = k1<<8 | uint64(b[i])
+	}
+	if len(b) > 0 {
+		h1 ^= bits.RotateLeft64(k1*murmurC1, 31) * murmurC2
+	}
+
+	h1 ^= uint64(n)
+	h2 ^= uint64(n)
+	h1 += h2
+	h2 += h1
+	h1, h2 = mix(h1), mix(h2)
+	return h1 + h2
+}
+
+// seededHasher mixes the hashes of a Hasher of the user with a seed of the store, so
+// colliding keys of a predictable Hasher still spread differently in every store
+type seededHasher[K comparable] struct {
+	hasher Hasher[K]
+	seed   uint64
+}
+
+func (h seededHasher[K]) Hash(key K) uint64 {
+	return mix(h.hasher.Hash(key) ^ h.seed)
 }
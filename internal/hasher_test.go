@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/spaolacci/murmur3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHash[K comparable]() *HashKey[K] {
+	h, err := NewHash[K](rand.Uint64())
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+type userID string
+
+type point struct {
+	X, Y int32
+}
+
+type padded struct {
+	A int8
+	B int64
+}
+
+type named struct {
+	ID   int
+	Name string
+}
+
+func (n named) Hash() uint64 {
+	return uint64(n.ID)
+}
+
+func TestHashKey_Seed(t *testing.T) {
+	assert.NotEqual(t, mustHash[string]().Hash("foo"), mustHash[string]().Hash("foo"),
+		"every instance has its own seed")
+	h := mustHash[string]()
+	assert.Equal(t, h.Hash("foo"), h.Hash("foo"))
+}
+
+func TestHashKey_DeterministicSeed(t *testing.T) {
+	a, _ := NewHash[string](42)
+	b, _ := NewHash[string](42)
+	assert.Equal(t, a.Hash("foo"), b.Hash("foo"))
+
+	cfg := Config[string, int]{Capacity: 100, Seed: 42}
+	s1, err := NewStoreWithConfig(cfg)
+	require.Nil(t, err)
+	defer s1.Close(context.Background())
+	s2, err := NewStoreWithConfig(cfg)
+	require.Nil(t, err)
+	defer s2.Close(context.Background())
+	assert.Equal(t, s1.hash.Hash("foo"), s2.hash.Hash("foo"))
+	assert.Equal(t, s1.policy.sketch, s2.policy.sketch)
+	assert.Equal(t, s1.policy.rand.Int63(), s2.policy.rand.Int63())
+}
+
+func TestMurmur64(t *testing.T) {
+	data := make([]byte, 100)
+	rand.Read(data)
+	for n := 0; n <= len(data); n++ {
+		seed := rand.Uint32()
+		assert.Equal(t, murmur3.Sum64WithSeed(data[:n], seed), murmur64(data[:n], uint64(seed)), "length %d", n)
+	}
+}
+
+func TestHashKey_NoAllocs(t *testing.T) {
+	s, i, p := mustHash[string](), mustHash[int](), mustHash[point]()
+	key := strings.Repeat("a", 40)
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		s.Hash(key)
+		i.Hash(42)
+		p.Hash(point{1, 2})
+	}))
+}
+
+func BenchmarkHashKey(b *testing.B) {
+	b.Run("string", func(b *testing.B) {
+		h, key := mustHash[string](), "user:1234567890"
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.Hash(key)
+		}
+	})
+	b.Run("int", func(b *testing.B) {
+		h := mustHash[int]()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.Hash(i)
+		}
+	})
+	b.Run("struct", func(b *testing.B) {
+		h := mustHash[[4]point]()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.Hash([4]point{{X: int32(i)}})
+		}
+	})
+}
+
+func TestHashKey_Kinds(t *testing.T) {
+	// strings built at runtime have distinct data pointers
+	a, b := strings.Repeat("a", 3), strings.Repeat("a", 3)
+	s := mustHash[string]()
+	assert.Equal(t, s.Hash(a), s.Hash(b))
+	assert.NotEqual(t, s.Hash(a), s.Hash("b"))
+
+	u := mustHash[userID]()
+	assert.Equal(t, hashKind(hashString), u.kind)
+	assert.Equal(t, u.Hash(userID(a)), u.Hash(userID(b)))
+
+	i := mustHash[int8]()
+	assert.Equal(t, hashKind(hashInt), i.kind)
+	assert.NotEqual(t, i.Hash(1), i.Hash(2))
+
+	f := mustHash[float64]()
+	assert.Equal(t, f.Hash(0), f.Hash(math.Copysign(0, -1)))
+	assert.NotEqual(t, f.Hash(1), f.Hash(2))
+
+	p := mustHash[point]()
+	assert.Equal(t, hashKind(hashMemory), p.kind)
+	assert.Equal(t, p.Hash(point{1, 2}), p.Hash(point{1, 2}))
+	assert.NotEqual(t, p.Hash(point{1, 2}), p.Hash(point{2, 1}))
+
+	arr := mustHash[[3]byte]()
+	assert.Equal(t, hashKind(hashMemory), arr.kind)
+	assert.NotEqual(t, arr.Hash([3]byte{1}), arr.Hash([3]byte{2}))
+
+	n := mustHash[named]()
+	assert.Equal(t, hashKind(hashHashable), n.kind)
+	assert.Equal(t, n.Hash(named{1, a}), n.Hash(named{1, b}))
+}
+
+func TestHashKey_NilHashable(t *testing.T) {
+	h := mustHash[Hashable]()
+	assert.Equal(t, hashKind(hashHashable), h.kind)
+	assert.Equal(t, h.Hash(nil), h.Hash(nil))
+	assert.NotEqual(t, h.Hash(nil), h.Hash(named{1, "a"}))
+
+	// a nil interface key is a valid key
+	store, err := NewStoreWithConfig(Config[Hashable, int]{Capacity: 100})
+	require.Nil(t, err)
+	defer store.Close(context.Background())
+	_, ok := store.Get(nil)
+	assert.False(t, ok)
+	store.SetWithResult(nil, 1, 0, true)
+	v, ok := store.Get(nil)
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	store.Delete(nil)
+	_, ok = store.Get(nil)
+	assert.False(t, ok)
+}
+
+func TestHashKey_Unhashable(t *testing.T) {
+	for name, err := range map[string]error{
+		"string field": hashErr[struct{ Name string }](),
+		"interface":    hashErr[any](),
+		"padding":      hashErr[padded](),
+		"float field":  hashErr[[2]float64](),
+		"blank field":  hashErr[struct{ _, A int }](),
+	} {
+		assert.Error(t, err, name)
+	}
+
+	_, err := NewStoreWithConfig(Config[any, int]{Capacity: 100})
+	var cfgErr *ConfigError
+	require.ErrorAs(t, err, &cfgErr)
+	assert.Equal(t, "Hasher", cfgErr.Field)
+
+	store, err := NewStoreWithConfig(Config[any, int]{Capacity: 100, Hasher: anyHasher{}})
+	require.Nil(t, err)
+	defer store.Close(context.Background())
+	store.Set("foo", 1, 0)
+	store.Set("foo", 1, 0)
+	v, ok := store.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+}
+
+func hashErr[K comparable]() error {
+	_, err := NewHash[K](0)
+	return err
+}
+
+type anyHasher struct{}
+
+func (anyHasher) Hash(key any) uint64 {
+	return uint64(len(key.(string)))
+}
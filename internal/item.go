@@ -27,6 +27,12 @@ type WriteBufItem[K comparable, V any] struct {
 	item       *Item[K, V]
 	code       int8
 	reSchedule bool
+	// reWeight asks maintenance to change the weight of an item outside the window
+	reWeight bool
+	weight   uint32
+	// done is closed by maintenance once the writes sent before it are processed, the
+	// item carries nothing else
+	done chan struct{}
 }
 
 type Item[K comparable, V any] struct {
@@ -36,12 +42,29 @@ type Item[K comparable, V any] struct {
 	// shard num
 	shardNum uint16
 
+	// whether the item is in its shard window, guarded by the shard lock.
+	// belong can not be used for this because the policy changes it without the shard lock
+	inWindow bool
+
+	// weight of the item, 1 unless the store has a weigher. It is changed by the
+	// owner of the list holding the item, and read by set to detect weight changes
+	weight atomic.Uint32
+
 	// key and value
 	key K
 	val V
 
 	// ddl expire time
 	expire atomic.Int64
+	// ttl is the ttl of the write that set expire, 0 when the expiry computed it
+	ttl atomic.Int64
+
+	// last write time, used by refresh after write
+	writeTime atomic.Int64
+	// accessTime is the last time the item was read or written, only kept with expire after access
+	accessTime atomic.Int64
+	// whether a background refresh of the item is queued or running
+	refreshing atomic.Bool
 
 	// normal list meta data
 	_list *List[K, V]
@@ -49,15 +72,18 @@ type Item[K comparable, V any] struct {
 	pre   *Item[K, V]
 
 	// timeWheel list meta data
-	wheelPre  *Item[K, V]
-	wheelNext *Item[K, V]
+	_wheelList *List[K, V]
+	wheelPre   *Item[K, V]
+	wheelNext  *Item[K, V]
 }
 
 func NewItem[K comparable, V any](key K, val V, expire int64) *Item[K, V] {
 	i := &Item[K, V]{
-		key: key,
-		val: val,
+		belong: ListUnknown,
+		key:    key,
+		val:    val,
 	}
+	i.weight.Store(1)
 	if expire > 0 {
 		i.expire.Store(expire)
 	}
@@ -69,42 +95,23 @@ func (i *Item[K, V]) isNew() bool {
 }
 
 func (i *Item[K, V]) isNewWheel() bool {
-	return i._list == nil && i.wheelPre == nil && i.wheelNext == nil && i.belong == ListUnknown
+	return i._wheelList == nil && i.wheelPre == nil && i.wheelNext == nil
 }
 
 func (i *Item[K, V]) Next(belong ListType) *Item[K, V] {
-	switch belong {
-	case ListProbation, ListProtection, ListWindow:
-		n := i.next
-		// because list is a ring list, the back item.next is list.root, but we want nil
-		if i._list != nil && &i._list.root != n {
-			return n
-		}
-	case ListTimeWheel:
-		n := i.wheelNext
-		// because list is a ring list, the back item.next is list.root, but we want nil
-		if i._list != nil && &i._list.root != n {
-			return n
-		}
+	n := i.getNext(belong)
+	// because list is a ring list, the back item.next is list.root, but we want nil
+	if l := i.getList(belong); l != nil && &l.root != n {
+		return n
 	}
 	return nil
 }
 
 func (i *Item[K, V]) Pre(belong ListType) *Item[K, V] {
-
-	switch belong {
-	case ListProbation, ListProtection, ListWindow:
-		p := i.pre
-		// because list is a ring list, the front item.pre is list.root, but we want nil
-		if i._list != nil && &i._list.root != p {
-			return p
-		}
-	case ListTimeWheel:
-		p := i.wheelPre
-		// because list is a ring list, the front item.pre is list.root, but we want nil
-		if i._list != nil && &i._list.root != p {
-			return p
-		}
+	p := i.getPrev(belong)
+	// because list is a ring list, the front item.pre is list.root, but we want nil
+	if l := i.getList(belong); l != nil && &l.root != p {
+		return p
 	}
 	return nil
 }
@@ -146,3 +153,22 @@ func (i *Item[K, V]) getNext(listType ListType) *Item[K, V] {
 	}
 	return nil
 }
+
+func (i *Item[K, V]) getList(listType ListType) *List[K, V] {
+	switch listType {
+	case ListProbation, ListProtection, ListWindow:
+		return i._list
+	case ListTimeWheel:
+		return i._wheelList
+	}
+	return nil
+}
+
+func (i *Item[K, V]) setList(l *List[K, V], listType ListType) {
+	switch listType {
+	case ListProbation, ListProtection, ListWindow:
+		i._list = l
+	case ListTimeWheel:
+		i._wheelList = l
+	}
+}
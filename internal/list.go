@@ -6,15 +6,18 @@ type List[K comparable, V any] struct {
 	root Item[K, V] // sentinel node
 
 	len int
-	cap int
+	// weight is the total weight of the items, cap is in weight units
+	weight int
+	cap    int
 }
 
 func (l *List[K, V]) Init() *List[K, V] {
 	l.root = Item[K, V]{} // sentinel node
 	l.root.setPre(&l.root, l.listType)
 	l.root.setNext(&l.root, l.listType)
-	l.root._list = l
+	l.root.setList(l, l.listType)
 	l.len = 0
+	l.weight = 0
 	return l
 }
 
@@ -27,7 +30,8 @@ func NewList[K comparable, V any](cap int, listType ListType) *List[K, V] {
 }
 
 func (l *List[K, V]) layInit() {
-	if l.root.next == nil {
+	// time wheel lists link their items with wheelNext, root.next is always nil for them
+	if l.root.getNext(l.listType) == nil {
 		l.Init()
 	}
 }
@@ -36,6 +40,11 @@ func (l *List[K, V]) Len() int {
 	return l.len
 }
 
+// Weight returns the total weight of the items in the list
+func (l *List[K, V]) Weight() int {
+	return l.weight
+}
+
 func (l *List[K, V]) Front() *Item[K, V] {
 	if l.len == 0 {
 		return nil
@@ -50,15 +59,15 @@ func (l *List[K, V]) Back() *Item[K, V] {
 	return l.root.Pre(l.listType)
 }
 
-// insert inserts newItem after atItem
+// insert inserts newItem after atItem, the list never evicts by itself, its owner
+// compares Weight and Cap to decide what to evict
 // a <-> b <-> c <-> d   newItem = x, atItem = c   ===>  a <-> b <-> c <->  [x]  <-> d
 func (l *List[K, V]) insert(newItem, atItem *Item[K, V]) *Item[K, V] {
-	var evicted *Item[K, V]
-	if l.len >= l.cap {
-		evicted = l.PopBack()
+	// the time wheel is an index besides the policy lists, it must not change belong
+	if l.listType != ListTimeWheel {
+		newItem.belong = l.listType
 	}
-
-	newItem.belong = l.listType
+	newItem.setList(l, l.listType)
 
 	newItem.setPre(atItem, l.listType)
 	newItem.setNext(atItem.getNext(l.listType), l.listType)
@@ -67,8 +76,11 @@ func (l *List[K, V]) insert(newItem, atItem *Item[K, V]) *Item[K, V] {
 	newItem.getNext(l.listType).setPre(newItem, l.listType)
 
 	l.len++
+	if l.listType != ListTimeWheel {
+		l.weight += int(newItem.weight.Load())
+	}
 
-	return evicted
+	return newItem
 }
 
 // remove removes i in list
@@ -78,12 +90,22 @@ func (l *List[K, V]) remove(i *Item[K, V]) {
 
 	i.setPre(nil, l.listType)
 	i.setNext(nil, l.listType)
+	i.setList(nil, l.listType)
 
-	i.belong = ListUnknown
+	if l.listType != ListTimeWheel {
+		i.belong = ListUnknown
+		l.weight -= int(i.weight.Load())
+	}
 
 	l.len--
 }
 
+// updateWeight changes the weight of i, which must be in the list
+func (l *List[K, V]) updateWeight(i *Item[K, V], weight uint32) {
+	l.weight += int(weight) - int(i.weight.Load())
+	i.weight.Store(weight)
+}
+
 // move moves i after at
 // a <-> b <-> c <-> d   i = b, at = c   ===>  a  <-> c <-> a  <-> d
 func (l *List[K, V]) move(i, at *Item[K, V]) {
@@ -103,7 +125,7 @@ func (l *List[K, V]) move(i, at *Item[K, V]) {
 
 // Remove removes i from l if the i is in the list l
 func (l *List[K, V]) Remove(i *Item[K, V]) *Item[K, V] {
-	if i._list == l {
+	if i.getList(l.listType) == l {
 		l.remove(i)
 	}
 	return i
@@ -118,12 +140,12 @@ func (l *List[K, V]) PushFront(i *Item[K, V]) *Item[K, V] {
 // PushBack insert a new item i at the back of the list l and return i
 func (l *List[K, V]) PushBack(i *Item[K, V]) *Item[K, V] {
 	l.layInit()
-	return l.insert(i, l.root.pre)
+	return l.insert(i, l.root.getPrev(l.listType))
 }
 
 // MoveToFront moves i to front of list
 func (l *List[K, V]) MoveToFront(i *Item[K, V]) {
-	if i._list != l || l.root.next == i {
+	if i.getList(l.listType) != l || l.root.getNext(l.listType) == i {
 		return
 	}
 	l.move(i, &l.root)
@@ -131,10 +153,10 @@ func (l *List[K, V]) MoveToFront(i *Item[K, V]) {
 
 // MoveToBack moves i to back of list
 func (l *List[K, V]) MoveToBack(i *Item[K, V]) {
-	if i._list != l || l.root.pre == i {
+	if i.getList(l.listType) != l || l.root.getPrev(l.listType) == i {
 		return
 	}
-	l.move(i, l.root.pre)
+	l.move(i, l.root.getPrev(l.listType))
 }
 
 func (l *List[K, V]) PopBack() *Item[K, V] {
@@ -0,0 +1,383 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRefreshWorkers = 4
+	refreshBuffSize       = 1024
+)
+
+// RefreshFailurePolicy decides what happens to an entry whose background refresh failed
+type RefreshFailurePolicy uint8
+
+const (
+	// RefreshKeep keeps serving the old value, the first read refreshAfter after the failure retries
+	RefreshKeep RefreshFailurePolicy = iota
+	// RefreshLog is like RefreshKeep but logs the error with the standard logger
+	RefreshLog
+	// RefreshEvict removes the entry, the next read loads it again
+	RefreshEvict
+)
+
+// ErrNotFound is returned to the waiters of a key that a BulkLoader left out of its result
+var ErrNotFound = errors.New("wtlfu: key not found by bulk loader")
+
+// Loader loads the value of a missing key
+type Loader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// BulkLoader loads the values of several missing keys at once, keys it cannot find are left out of the result
+type BulkLoader[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// LoadingConfig holds the options of a LoadingStore
+type LoadingConfig[K comparable, V any] struct {
+	// Loader loads a missing key, it is required
+	Loader Loader[K, V]
+	// BulkLoader is optional, GetAll falls back to Loader without it
+	BulkLoader BulkLoader[K, V]
+	// RefreshAfterWrite reloads entries read after they are older than it, 0 disables refresh
+	RefreshAfterWrite time.Duration
+	// RefreshFailure decides what happens when a refresh fails, default RefreshKeep
+	RefreshFailure RefreshFailurePolicy
+	// RefreshWorkers is the number of goroutines running refreshes, default 4
+	RefreshWorkers int
+	// StaleWhileRevalidate serves entries expired less than it ago as stale while they are refreshed
+	StaleWhileRevalidate time.Duration
+	// StaleIfError serves entries expired less than it ago as stale when their load fails
+	StaleIfError time.Duration
+}
+
+// Validate checks a LoadingConfig
+func (c LoadingConfig[K, V]) Validate() error {
+	if c.Loader == nil {
+		return &ConfigError{"Loader", nil, "must not be nil"}
+	}
+	if c.RefreshAfterWrite < 0 {
+		return &ConfigError{"RefreshAfterWrite", c.RefreshAfterWrite, "must be positive"}
+	}
+	if c.RefreshFailure > RefreshEvict {
+		return &ConfigError{"RefreshFailure", c.RefreshFailure, "unknown policy"}
+	}
+	if c.RefreshWorkers < 0 {
+		return &ConfigError{"RefreshWorkers", c.RefreshWorkers, "must be positive"}
+	}
+	if c.StaleWhileRevalidate < 0 {
+		return &ConfigError{"StaleWhileRevalidate", c.StaleWhileRevalidate, "must be positive"}
+	}
+	if c.StaleIfError < 0 {
+		return &ConfigError{"StaleIfError", c.StaleIfError, "must be positive"}
+	}
+	return nil
+}
+
+// LoadingStore is a Store that loads missing keys, concurrent misses of the same key share one load
+type LoadingStore[K comparable, V any] struct {
+	*Store[K, V]
+	loader         Loader[K, V]
+	bulkLoader     BulkLoader[K, V]
+	group          *group[K, V]
+	refreshFailure RefreshFailurePolicy
+	quit           chan struct{}
+	quitOnce       sync.Once
+	workers        sync.WaitGroup
+
+	// stale serving windows in nanoseconds
+	staleWhileRevalidate int64
+	staleIfError         int64
+}
+
+func NewLoadingStore[K comparable, V any](store *Store[K, V], cfg LoadingConfig[K, V]) (*LoadingStore[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	s := &LoadingStore[K, V]{
+		Store:          store,
+		loader:         cfg.Loader,
+		bulkLoader:     cfg.BulkLoader,
+		group:          newGroup[K, V](),
+		refreshFailure: cfg.RefreshFailure,
+		quit:           make(chan struct{}),
+
+		staleWhileRevalidate: cfg.StaleWhileRevalidate.Nanoseconds(),
+		staleIfError:         cfg.StaleIfError.Nanoseconds(),
+	}
+	grace := cfg.StaleWhileRevalidate
+	if cfg.StaleIfError > grace {
+		grace = cfg.StaleIfError
+	}
+	if grace > 0 {
+		store.enableStale(grace)
+	}
+	if cfg.RefreshAfterWrite > 0 || cfg.StaleWhileRevalidate > 0 {
+		workers := cfg.RefreshWorkers
+		if workers == 0 {
+			workers = DefaultRefreshWorkers
+		}
+		refreshBuf := store.enableRefresh(cfg.RefreshAfterWrite, refreshBuffSize)
+		for i := 0; i < workers; i++ {
+			s.workers.Add(1)
+			go s.refreshLoop(refreshBuf)
+		}
+	}
+	return s, nil
+}
+
+// Close closes the store like Store.Close, then stops the refresh workers and waits
+// for them, a refresh loading a value when ctx is done keeps its worker running
+func (s *LoadingStore[K, V]) Close(ctx context.Context) error {
+	err := s.Store.Close(ctx)
+	s.quitOnce.Do(func() { close(s.quit) })
+	stopped := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// refreshLoop reloads the items queued by Get until the store is closed
+func (s *LoadingStore[K, V]) refreshLoop(refreshBuf <-chan *Item[K, V]) {
+	defer s.workers.Done()
+	for {
+		select {
+		case item := <-refreshBuf:
+			s.refresh(item)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// refresh reloads item, the old value is served until the new one is stored.
+// A refresh shares the in-flight load of its key, if any
+func (s *LoadingStore[K, V]) refresh(item *Item[K, V]) {
+	defer item.refreshing.Store(false)
+	key := item.key
+	_, err := s.group.do(context.Background(), key, func() (V, error) {
+		v, err := s.callLoader(context.Background(), key)
+		// a deleted or replaced entry must not be brought back by its refresh
+		if err == nil && s.alive(item) {
+			s.setLoaded(key, v)
+		}
+		return v, err
+	})
+	if err == nil {
+		return
+	}
+	switch s.refreshFailure {
+	case RefreshLog:
+		log.Printf("wtlfu: refresh of key %v failed: %v", key, err)
+	case RefreshEvict:
+		s.deleteItem(item)
+		return
+	}
+	// the old value is kept, the next refresh waits refreshAfter instead of every read retrying
+	item.writeTime.Store(s.timerWheel.clock.nowNano())
+}
+
+// GetOrLoad returns the cached value of key, or loads and caches it on miss.
+// A loaded value is always admitted to the window, load errors are returned to
+// every waiter and never cached
+func (s *LoadingStore[K, V]) GetOrLoad(ctx context.Context, key K) (V, error) {
+	v, _, err := s.GetOrLoadStale(ctx, key)
+	return v, err
+}
+
+// GetOrLoadStale is GetOrLoad that may serve an expired value, reported by stale:
+// within StaleWhileRevalidate it is returned at once while a background refresh
+// reloads it, within StaleIfError it is returned instead of a load error
+func (s *LoadingStore[K, V]) GetOrLoadStale(ctx context.Context, key K) (v V, stale bool, err error) {
+	if s.closing.Load() {
+		return v, false, ErrClosed
+	}
+	v, item, stale, ok := s.get(key, s.staleWhileRevalidate)
+	s.recordRead(ok)
+	if ok {
+		if stale {
+			s.queueRefresh(item)
+		}
+		return v, stale, nil
+	}
+	v, err = s.group.do(ctx, key, func() (V, error) {
+		return s.load(ctx, key)
+	})
+	if err != nil && s.staleIfError > 0 {
+		if old, _, oldStale, ok := s.get(key, s.staleIfError); ok {
+			return old, oldStale, nil
+		}
+	}
+	return v, false, err
+}
+
+// GetAll returns the values of keys, hits are served by the shards and recorded
+// like Get, keys already being loaded are waited for, and the remaining misses
+// are loaded with a single bulk load. Keys the bulk loader did not find are
+// left out of the result
+func (s *LoadingStore[K, V]) GetAll(ctx context.Context, keys []K) (map[K]V, error) {
+	if s.closing.Load() {
+		return nil, ErrClosed
+	}
+	result := make(map[K]V, len(keys))
+	leaders := make(map[K]*call[V])
+	var leaderKeys, waitKeys []K
+	var waitCalls []*call[V]
+	for _, key := range keys {
+		if _, ok := result[key]; ok {
+			continue
+		}
+		if _, ok := leaders[key]; ok {
+			continue
+		}
+		if v, ok := s.Get(key); ok {
+			result[key] = v
+			continue
+		}
+		c, leader := s.group.start(key)
+		if leader {
+			leaders[key] = c
+			leaderKeys = append(leaderKeys, key)
+		} else {
+			waitKeys = append(waitKeys, key)
+			waitCalls = append(waitCalls, c)
+		}
+	}
+
+	// load our own keys before waiting for others, so two GetAll never wait for each other
+	if len(leaderKeys) > 0 {
+		if err := s.loadAll(ctx, leaderKeys, leaders, result); err != nil {
+			return nil, err
+		}
+	}
+	for i, c := range waitCalls {
+		v, err := c.wait(ctx)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result[waitKeys[i]] = v
+	}
+	return result, nil
+}
+
+// loadAll loads keys whose calls were started by this goroutine and finishes every call
+func (s *LoadingStore[K, V]) loadAll(ctx context.Context, keys []K, calls map[K]*call[V], result map[K]V) error {
+	var zero V
+	normalReturn := false
+	defer func() {
+		// waiters must not hang if a loader panics
+		if !normalReturn {
+			err := fmt.Errorf("wtlfu: loader panicked: %v", recover())
+			for key, c := range calls {
+				if !isDone(c) {
+					s.group.finish(key, c, zero, err)
+				}
+			}
+			panic(err)
+		}
+	}()
+
+	if s.bulkLoader == nil {
+		// every key gets its own result, the first error is returned once all keys are done
+		var firstErr error
+		for _, key := range keys {
+			v, err := s.load(ctx, key)
+			s.group.finish(key, calls[key], v, err)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			result[key] = v
+		}
+		normalReturn = true
+		return firstErr
+	}
+
+	start := time.Now()
+	loaded, err := s.bulkLoader(ctx, keys)
+	s.recordLoad(start, err)
+	normalReturn = true
+	for _, key := range keys {
+		c := calls[key]
+		if err != nil {
+			s.group.finish(key, c, zero, err)
+			continue
+		}
+		v, ok := loaded[key]
+		if !ok {
+			s.group.finish(key, c, zero, ErrNotFound)
+			continue
+		}
+		if setErr := s.setLoaded(key, v); setErr != nil {
+			s.group.finish(key, c, zero, setErr)
+			err = setErr
+			continue
+		}
+		result[key] = v
+		s.group.finish(key, c, v, nil)
+	}
+	return err
+}
+
+// setLoaded stores a loaded value, bypassing the doorkeeper, with the ttl of the entry
+// it replaces when the store has no default ttl. It returns ErrClosed if the store was
+// closed during the load
+func (s *LoadingStore[K, V]) setLoaded(key K, v V) error {
+	if s.set(key, v, s.reloadTTL(key), true) == SetClosed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// load calls the loader and caches its value
+func (s *LoadingStore[K, V]) load(ctx context.Context, key K) (V, error) {
+	v, err := s.callLoader(ctx, key)
+	if err != nil {
+		return v, err
+	}
+	if err := s.setLoaded(key, v); err != nil {
+		var zero V
+		return zero, err
+	}
+	return v, nil
+}
+
+// callLoader calls the loader and records the load
+func (s *LoadingStore[K, V]) callLoader(ctx context.Context, key K) (V, error) {
+	start := time.Now()
+	v, err := s.loader(ctx, key)
+	s.recordLoad(start, err)
+	return v, err
+}
+
+// recordLoad records a load started at start
+func (s *LoadingStore[K, V]) recordLoad(start time.Time, err error) {
+	if err != nil {
+		s.stats.RecordLoadFailure(time.Since(start))
+	} else {
+		s.stats.RecordLoadSuccess(time.Since(start))
+	}
+}
+
+func isDone[V any](c *call[V]) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -15,26 +15,56 @@ func (l *Lru[K, V]) access(i *Item[K, V]) {
 	l.list.MoveToFront(i)
 }
 
-// Add try to add a new Item into lru list at front, and check if the lru list is full
-// return true and evicted item if the lru list is full
-func (l *Lru[K, V]) Add(i *Item[K, V]) (*Item[K, V], bool) {
-	i.belong = l.list.listType
-	evictItem := l.list.PushFront(i)
-	if evictItem == nil {
-		return nil, false
+// Add adds a new Item into lru list at front, and returns the items evicted from
+// the back to keep the total weight within cap. i itself is never evicted
+func (l *Lru[K, V]) Add(i *Item[K, V]) []*Item[K, V] {
+	l.list.PushFront(i)
+	i.inWindow = true
+	return l.evict(i)
+}
+
+// UpdateWeight changes the weight of i and returns the items evicted to keep the
+// total weight within cap, which may include i
+func (l *Lru[K, V]) UpdateWeight(i *Item[K, V], weight uint32) []*Item[K, V] {
+	l.list.updateWeight(i, weight)
+	return l.evict(nil)
+}
+
+// evict pops items from the back until the weight fits in cap, keep is never evicted
+func (l *Lru[K, V]) evict(keep *Item[K, V]) []*Item[K, V] {
+	var evicted []*Item[K, V]
+	for l.list.Weight() > l.list.Cap() {
+		back := l.list.Back()
+		if back == nil || back == keep {
+			break
+		}
+		l.Remove(back)
+		evicted = append(evicted, back)
 	}
-	return evictItem, true
+	return evicted
+}
+
+// Resize changes the weight the lru holds and returns the items evicted to fit in it
+func (l *Lru[K, V]) Resize(cap int) []*Item[K, V] {
+	l.list.cap = cap
+	return l.evict(nil)
 }
 
 // Remove removes an Item from lru list
 func (l *Lru[K, V]) Remove(i *Item[K, V]) {
 	l.list.Remove(i)
+	i.inWindow = false
 }
 
 func (l *Lru[K, V]) Len() int {
 	return l.list.Len()
 }
 
+// Weight returns the total weight of the items in the lru
+func (l *Lru[K, V]) Weight() int {
+	return l.list.Weight()
+}
+
 func (l *Lru[K, V]) Cap() int {
 	return l.list.Cap()
 }
@@ -0,0 +1,84 @@
+package internal
+
+// Metrics is a snapshot of the counters and sizes of a store
+type Metrics struct {
+	Stats Stats
+	// Len is the number of entries, including expired entries not yet cleaned up
+	Len int
+	// WindowLen, ProbationLen and ProtectedLen are the number of entries in each segment,
+	// entries waiting for admission to the main cache are in none of them
+	WindowLen    int
+	ProbationLen int
+	ProtectedLen int
+	// TimerWheelLen is the number of entries scheduled in each level of the timer wheel
+	TimerWheelLen []int
+}
+
+// Metrics returns a snapshot of the store, the store lock is held while the policy and
+// the timer wheel are read, the shard locks only while their own window is read
+func (s *Store[K, V]) Metrics() Metrics {
+	m := Metrics{Stats: s.stats.Snapshot()}
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		m.Len += len(shard.dict)
+		m.WindowLen += shard.window.Len()
+		shard.mu.RUnlock()
+	}
+
+	s.mu.Lock()
+	m.ProbationLen = s.policy.mainCache.firstSegment.Len()
+	m.ProtectedLen = s.policy.mainCache.secondSegment.Len()
+	m.TimerWheelLen = make([]int, len(s.timerWheel.wheel))
+	for i, level := range s.timerWheel.wheel {
+		for _, list := range level {
+			m.TimerWheelLen[i] += list.Len()
+		}
+	}
+	s.mu.Unlock()
+	return m
+}
+
+// Description is the configuration, counters and shard sizes of a store
+type Description struct {
+	// Capacity is the configured number of entries, MaxWeight the weight bounding the store
+	Capacity   int
+	MaxWeight  int
+	ShardCount int
+	// WindowCapacity, MainCapacity and ProtectedCapacity are the current split of MaxWeight
+	WindowCapacity    int
+	MainCapacity      int
+	ProtectedCapacity int
+	Hits              uint64
+	Misses            uint64
+	// Removals counts the entries that left the store by reason name
+	Removals map[string]uint64
+	// ShardLens is the number of entries in each shard
+	ShardLens []int
+}
+
+// Describe returns the description of the store, each shard lock is held only while its size is read
+func (s *Store[K, V]) Describe() Description {
+	split := s.Split()
+	stats := s.stats.Snapshot()
+	d := Description{
+		Capacity:          s.cap,
+		MaxWeight:         s.maxWeight,
+		ShardCount:        s.shardNum,
+		WindowCapacity:    split.Window,
+		MainCapacity:      split.Main,
+		ProtectedCapacity: split.Protected,
+		Hits:              stats.Hits,
+		Misses:            stats.Misses,
+		Removals:          make(map[string]uint64, len(stats.Removals)),
+		ShardLens:         make([]int, len(s.shards)),
+	}
+	for reason, n := range stats.Removals {
+		d.Removals[RemoveReason(reason).String()] = n
+	}
+	for i, shard := range s.shards {
+		shard.mu.RLock()
+		d.ShardLens[i] = len(shard.dict)
+		shard.mu.RUnlock()
+	}
+	return d
+}
@@ -6,7 +6,7 @@ import (
 )
 
 type node[V any] struct {
-	next *node[V]
+	next atomic.Pointer[node[V]]
 	val  V
 }
 
@@ -32,18 +32,18 @@ func (q *Queue[V]) Push(x V) {
 	prev := q.head.Swap(n).(*node[V]) // 使用 Swap 方法替代直接赋值
 
 	// release node to consumer
-	prev.next = n // 不再需要使用 atomic.StorePointer
+	prev.next.Store(n)
 }
 
 func (q *Queue[V]) Pop() (V, bool) {
 	tail := q.tail.Load().(*node[V]) // 使用 Load 方法替代直接赋值
-	next := tail.next
+	next := tail.next.Load()
 	if next != nil {
 		var null V
 		v := next.val
 		next.val = null
 		q.tail.Store(next) // 使用 Store 方法替代直接赋值
-		tail.next = nil
+		tail.next.Store(nil)
 		q.nodePool.Put(tail)
 		return v, true
 	}
@@ -53,5 +53,5 @@ func (q *Queue[V]) Pop() (V, bool) {
 
 func (q *Queue[V]) Empty() bool {
 	tail := q.tail.Load().(*node[V]) // 使用 Load 方法替代直接赋值
-	return tail.next == nil
+	return tail.next.Load() == nil
 }
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// call is an in-flight or completed load of a key
+type call[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// group coalesces concurrent loads of the same key into one call
+type group[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*call[V]
+}
+
+func newGroup[K comparable, V any]() *group[K, V] {
+	return &group[K, V]{calls: make(map[K]*call[V])}
+}
+
+// start returns the in-flight call of key, or registers a new one.
+// The caller that gets leader == true must run the load and finish the call
+func (g *group[K, V]) start(key K) (c *call[V], leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	c = &call[V]{done: make(chan struct{})}
+	g.calls[key] = c
+	return c, true
+}
+
+// finish publishes the result of c to all waiters and forgets key, so errors are never cached
+func (g *group[K, V]) finish(key K, c *call[V], val V, err error) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	c.val, c.err = val, err
+	close(c.done)
+}
+
+// do runs fn once for all concurrent callers of the same key, a waiter
+// gives up when its own ctx is done while the leader keeps loading
+func (g *group[K, V]) do(ctx context.Context, key K, fn func() (V, error)) (V, error) {
+	c, leader := g.start(key)
+	if !leader {
+		return c.wait(ctx)
+	}
+	return g.run(key, c, fn)
+}
+
+// run executes fn for the call c started by this goroutine and finishes it
+func (g *group[K, V]) run(key K, c *call[V], fn func() (V, error)) (V, error) {
+	var val V
+	var err error
+	normalReturn := false
+	defer func() {
+		// waiters must not hang if fn panics, the panic goes on in the leader
+		if !normalReturn {
+			var zero V
+			g.finish(key, c, zero, fmt.Errorf("wtlfu: loader panicked: %v", recover()))
+			panic(c.err)
+		}
+	}()
+	val, err = fn()
+	normalReturn = true
+	g.finish(key, c, val, err)
+	return val, err
+}
+
+// wait waits for c to finish or ctx to be done
+func (c *call[V]) wait(ctx context.Context) (V, error) {
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var sketchKinds = []SketchKind{SketchRows, SketchBlocked}
+
+func testSeeds() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
+
+func TestSketch_IncrementReset(t *testing.T) {
+	hash := mustHash[int]()
+	for _, kind := range sketchKinds {
+		for _, conservative := range []bool{false, true} {
+			testSketchIncrementReset(t, newFrequencySketch(kind, 1024, conservative, testSeeds()), hash)
+		}
+	}
+}
+
+func testSketchIncrementReset(t *testing.T, s frequencySketch, hash *HashKey[int]) {
+	h := hash.Hash(1)
+	for i := 1; i <= 20; i++ {
+		s.increment(h)
+		if i <= 15 {
+			assert.Equal(t, int64(i), s.estimate(h))
+		}
+	}
+	assert.Equal(t, int64(15), s.estimate(h), "counters saturate at 15")
+	s.reset()
+	assert.Equal(t, int64(7), s.estimate(h))
+	assert.Equal(t, int64(0), s.estimate(hash.Hash(2)))
+}
+
+// sketchError increments keys following a zipf distribution and returns the mean
+// overestimation of the frequency of the distinct keys
+func sketchError(kind SketchKind, conservative bool, width int64, seed int64) float64 {
+	hash := mustHash[uint64]()
+	s := newFrequencySketch(kind, width, conservative, testSeeds())
+	zipf := rand.NewZipf(rand.New(rand.NewSource(seed)), 1.01, 1, 1<<20)
+	counts := make(map[uint64]int64)
+	for i := int64(0); i < width*4; i++ {
+		key := zipf.Uint64()
+		counts[key]++
+		s.increment(hash.Hash(key))
+	}
+	var over int64
+	for key, count := range counts {
+		if count > 15 {
+			count = 15
+		}
+		over += s.estimate(hash.Hash(key)) - count
+	}
+	return float64(over) / float64(len(counts))
+}
+
+func TestSketch_ErrorRate(t *testing.T) {
+	for _, width := range []int64{1 << 10, 1 << 14} {
+		var rows, blocked, conservativeRows, conservativeBlocked float64
+		for seed := int64(0); seed < 5; seed++ {
+			rows += sketchError(SketchRows, false, width, seed) / 5
+			blocked += sketchError(SketchBlocked, false, width, seed) / 5
+			conservativeRows += sketchError(SketchRows, true, width, seed) / 5
+			conservativeBlocked += sketchError(SketchBlocked, true, width, seed) / 5
+		}
+		// the zipf stream has about as many distinct keys as counters per row, the
+		// overestimation stays a fraction of the max count
+		assert.Less(t, rows, 2.0, "width %d", width)
+		assert.Less(t, blocked, rows*1.1+0.1, "width %d: rows %f, blocked %f", width, rows, blocked)
+		// a conservative update never increments a counter above the estimate, the
+		// cold keys of the tail are overestimated less
+		assert.Less(t, conservativeRows, rows*0.6, "width %d", width)
+		assert.Less(t, conservativeBlocked, blocked*0.6, "width %d", width)
+	}
+}
+
+func benchmarkSketch(b *testing.B, kind SketchKind, conservative, estimate bool) {
+	// larger than the cpu caches, so every row or block is a cache miss
+	s := newFrequencySketch(kind, 1<<22, conservative, testSeeds())
+	hash := mustHash[int]()
+	hashes := make([]uint64, 1<<22)
+	for i := range hashes {
+		hashes[i] = hash.Hash(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h := hashes[i&(len(hashes)-1)]
+		if estimate {
+			s.estimate(h)
+		} else {
+			s.increment(h)
+		}
+	}
+}
+
+func BenchmarkSketch(b *testing.B) {
+	for _, kind := range sketchKinds {
+		b.Run(kind.String()+"/increment", func(b *testing.B) {
+			benchmarkSketch(b, kind, false, false)
+		})
+		b.Run(kind.String()+"/conservative", func(b *testing.B) {
+			benchmarkSketch(b, kind, true, false)
+		})
+		b.Run(kind.String()+"/estimate", func(b *testing.B) {
+			benchmarkSketch(b, kind, false, true)
+		})
+	}
+}
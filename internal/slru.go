@@ -4,28 +4,32 @@ type SLru[K comparable, V any] struct {
 	firstSegment  *List[K, V]
 	secondSegment *List[K, V]
 	cap           int
+	// probationRatio is kept to split the weight again on resize
+	probationRatio float64
 }
 
-func newSLru[K comparable, V any](cap int) *SLru[K, V] {
-	fc := cap / 5
-	sc := cap - fc
+func newSLru[K comparable, V any](cap int, probationRatio float64) *SLru[K, V] {
 	slru := SLru[K, V]{
-		firstSegment:  NewList[K, V](fc, ListProbation),
-		secondSegment: NewList[K, V](sc, ListProtection),
-		cap:           cap,
+		// probation is bounded by the whole slru capacity, see TinyLFU.evict
+		firstSegment:   NewList[K, V](0, ListProbation),
+		secondSegment:  NewList[K, V](0, ListProtection),
+		probationRatio: probationRatio,
 	}
+	slru.resize(cap)
 	return &slru
 }
 
-// add try to add a new Item into probation at front , and check if the probation list is full
-func (s *SLru[K, V]) add(i *Item[K, V]) *Item[K, V] {
-	var evicted *Item[K, V]
-	if s.firstSegment.Len()+s.secondSegment.Len() >= s.cap {
-		evicted = s.firstSegment.PopBack()
-	}
-	i.belong = ListProbation
-	evicted = s.firstSegment.PushFront(i)
-	return evicted
+// resize changes the weight of the slru and its protection segment, items over the
+// protection weight are demoted, the caller evicts if the slru is over its weight
+func (s *SLru[K, V]) resize(cap int) {
+	s.cap = cap
+	s.secondSegment.cap = cap - int(float64(cap)*s.probationRatio)
+	s.demote()
+}
+
+// add adds a new Item into probation at front, the caller evicts if the slru is over its weight
+func (s *SLru[K, V]) add(i *Item[K, V]) {
+	s.firstSegment.PushFront(i)
 }
 
 // access accesses an item and update the order
@@ -35,25 +39,39 @@ func (s *SLru[K, V]) access(i *Item[K, V]) {
 	case ListProbation:
 		// If access an item in probation segment, just move it to the protection segment
 		s.firstSegment.remove(i)
-		// If probation segment is full, evict one into probation segment
-		i.belong = ListProtection
-		evicted := s.secondSegment.PushFront(i)
-		if evicted != nil {
-			evicted.belong = ListProbation
-			s.secondSegment.PushFront(evicted)
-		}
+		s.secondSegment.PushFront(i)
+		s.demote()
 	case ListProtection:
 		// If access an item in protection segment, adjust the order
 		s.secondSegment.MoveToFront(i)
-	default:
-		panic("error")
 	}
 }
 
-// maybeVictim returns the victim item if the slru is full
-func (s *SLru[K, V]) maybeVictim() *Item[K, V] {
-	if s.firstSegment.Len()+s.secondSegment.Len() < s.cap {
-		return nil
+// demote moves items from the back of protection to probation while protection is over its weight
+func (s *SLru[K, V]) demote() {
+	for s.secondSegment.Weight() > s.secondSegment.Cap() && s.secondSegment.Len() > 1 {
+		s.firstSegment.PushFront(s.secondSegment.PopBack())
+	}
+}
+
+// updateWeight changes the weight of an item in the slru
+func (s *SLru[K, V]) updateWeight(i *Item[K, V], weight uint32) {
+	switch i.belong {
+	case ListProbation:
+		s.firstSegment.updateWeight(i, weight)
+	case ListProtection:
+		s.secondSegment.updateWeight(i, weight)
+		s.demote()
+	}
+}
+
+// victim returns the item the next eviction would remove, not counting keep
+func (s *SLru[K, V]) victim(keep *Item[K, V]) *Item[K, V] {
+	if back := s.firstSegment.Back(); back != nil && back != keep {
+		return back
+	}
+	if back := s.secondSegment.Back(); back != nil {
+		return back
 	}
 	return s.firstSegment.Back()
 }
@@ -72,3 +90,8 @@ func (s *SLru[K, V]) remove(i *Item[K, V]) {
 func (s *SLru[K, V]) len() int {
 	return s.firstSegment.Len() + s.secondSegment.Len()
 }
+
+// weight returns the total weight of items both in probation and protection
+func (s *SLru[K, V]) weight() int {
+	return s.firstSegment.Weight() + s.secondSegment.Weight()
+}
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"math/rand"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// removeReasonCount is the number of RemoveReason values
+	removeReasonCount = int(CLOSED) + 1
+	maxStatsStripes   = 64
+)
+
+// Stats is a snapshot of the counters of a store
+type Stats struct {
+	Hits          uint64
+	Misses        uint64
+	LoadSuccesses uint64
+	LoadFailures  uint64
+	// TotalLoadTime is the time spent loading, successful or not
+	TotalLoadTime time.Duration
+	// Removals counts the entries that left the store, indexed by RemoveReason
+	Removals [removeReasonCount]uint64
+	// DoorkeeperRejections counts the first writes rejected by the doorkeeper
+	DoorkeeperRejections uint64
+	// AdmissionRejections counts the window candidates that lost against the main cache victim
+	AdmissionRejections uint64
+}
+
+// Requests returns the number of reads, hits and misses
+func (s Stats) Requests() uint64 {
+	return s.Hits + s.Misses
+}
+
+// HitRatio returns the share of reads that hit, 1 if there was no read
+func (s Stats) HitRatio() float64 {
+	requests := s.Requests()
+	if requests == 0 {
+		return 1
+	}
+	return float64(s.Hits) / float64(requests)
+}
+
+// MissRatio returns the share of reads that missed, 0 if there was no read
+func (s Stats) MissRatio() float64 {
+	requests := s.Requests()
+	if requests == 0 {
+		return 0
+	}
+	return float64(s.Misses) / float64(requests)
+}
+
+// Loads returns the number of loads, successful or not
+func (s Stats) Loads() uint64 {
+	return s.LoadSuccesses + s.LoadFailures
+}
+
+// AverageLoadPenalty returns the average time spent by a load
+func (s Stats) AverageLoadPenalty() time.Duration {
+	loads := s.Loads()
+	if loads == 0 {
+		return 0
+	}
+	return s.TotalLoadTime / time.Duration(loads)
+}
+
+// Removed returns the number of entries removed for reason
+func (s Stats) Removed(reason RemoveReason) uint64 {
+	if int(reason) >= removeReasonCount {
+		return 0
+	}
+	return s.Removals[reason]
+}
+
+// Minus returns the difference between s and an older snapshot, counters never go below 0
+func (s Stats) Minus(other Stats) Stats {
+	d := Stats{
+		Hits:                 sub(s.Hits, other.Hits),
+		Misses:               sub(s.Misses, other.Misses),
+		LoadSuccesses:        sub(s.LoadSuccesses, other.LoadSuccesses),
+		LoadFailures:         sub(s.LoadFailures, other.LoadFailures),
+		TotalLoadTime:        time.Duration(sub(uint64(s.TotalLoadTime), uint64(other.TotalLoadTime))),
+		DoorkeeperRejections: sub(s.DoorkeeperRejections, other.DoorkeeperRejections),
+		AdmissionRejections:  sub(s.AdmissionRejections, other.AdmissionRejections),
+	}
+	for i := range d.Removals {
+		d.Removals[i] = sub(s.Removals[i], other.Removals[i])
+	}
+	return d
+}
+
+func sub(a, b uint64) uint64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
+
+// StatsCounter records the events of a store, it must be safe for concurrent use
+type StatsCounter interface {
+	RecordHits(count uint64)
+	RecordMisses(count uint64)
+	RecordLoadSuccess(loadTime time.Duration)
+	RecordLoadFailure(loadTime time.Duration)
+	RecordRemoval(reason RemoveReason)
+	RecordDoorkeeperRejection()
+	RecordAdmissionRejection()
+	// Snapshot returns the current values of the counters
+	Snapshot() Stats
+}
+
+// noopStats is the StatsCounter of a store that does not record stats
+type noopStats struct{}
+
+func (noopStats) RecordHits(uint64)               {}
+func (noopStats) RecordMisses(uint64)             {}
+func (noopStats) RecordLoadSuccess(time.Duration) {}
+func (noopStats) RecordLoadFailure(time.Duration) {}
+func (noopStats) RecordRemoval(RemoveReason)      {}
+func (noopStats) RecordDoorkeeperRejection()      {}
+func (noopStats) RecordAdmissionRejection()       {}
+func (noopStats) Snapshot() Stats                 { return Stats{} }
+
+// statsStripe holds one share of the counters, padded so stripes do not share a cache line
+type statsStripe struct {
+	hits                 atomic.Uint64
+	misses               atomic.Uint64
+	loadSuccesses        atomic.Uint64
+	loadFailures         atomic.Uint64
+	totalLoadTime        atomic.Int64
+	removals             [removeReasonCount]atomic.Uint64
+	doorkeeperRejections atomic.Uint64
+	admissionRejections  atomic.Uint64
+	_                    [64]byte
+}
+
+// stripedStats is the default StatsCounter, every event is added to a random stripe
+// so concurrent readers rarely write the same cache line, and Snapshot sums the stripes
+type stripedStats struct {
+	stripes []statsStripe
+	mask    uint32
+}
+
+// NewStatsCounter returns the default StatsCounter
+func NewStatsCounter() StatsCounter {
+	n := 1
+	for n < runtime.NumCPU() && n < maxStatsStripes {
+		n *= 2
+	}
+	return &stripedStats{stripes: make([]statsStripe, n), mask: uint32(n - 1)}
+}
+
+func (s *stripedStats) stripe() *statsStripe {
+	// the global source of math/rand is lock free when it is not seeded
+	return &s.stripes[rand.Uint32()&s.mask]
+}
+
+func (s *stripedStats) RecordHits(count uint64) {
+	s.stripe().hits.Add(count)
+}
+
+func (s *stripedStats) RecordMisses(count uint64) {
+	s.stripe().misses.Add(count)
+}
+
+func (s *stripedStats) RecordLoadSuccess(loadTime time.Duration) {
+	st := s.stripe()
+	st.loadSuccesses.Add(1)
+	st.totalLoadTime.Add(int64(loadTime))
+}
+
+func (s *stripedStats) RecordLoadFailure(loadTime time.Duration) {
+	st := s.stripe()
+	st.loadFailures.Add(1)
+	st.totalLoadTime.Add(int64(loadTime))
+}
+
+func (s *stripedStats) RecordRemoval(reason RemoveReason) {
+	if int(reason) < removeReasonCount {
+		s.stripe().removals[reason].Add(1)
+	}
+}
+
+func (s *stripedStats) RecordDoorkeeperRejection() {
+	s.stripe().doorkeeperRejections.Add(1)
+}
+
+func (s *stripedStats) RecordAdmissionRejection() {
+	s.stripe().admissionRejections.Add(1)
+}
+
+func (s *stripedStats) Snapshot() Stats {
+	var stats Stats
+	for i := range s.stripes {
+		st := &s.stripes[i]
+		stats.Hits += st.hits.Load()
+		stats.Misses += st.misses.Load()
+		stats.LoadSuccesses += st.loadSuccesses.Load()
+		stats.LoadFailures += st.loadFailures.Load()
+		stats.TotalLoadTime += time.Duration(st.totalLoadTime.Load())
+		for r := range st.removals {
+			stats.Removals[r] += st.removals[r].Load()
+		}
+		stats.DoorkeeperRejections += st.doorkeeperRejections.Load()
+		stats.AdmissionRejections += st.admissionRejections.Load()
+	}
+	return stats
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripedStats(t *testing.T) {
+	stats := NewStatsCounter()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				stats.RecordHits(1)
+				stats.RecordMisses(2)
+				stats.RecordRemoval(EVICTED)
+				stats.RecordLoadSuccess(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := stats.Snapshot()
+	assert.Equal(t, uint64(8000), snapshot.Hits)
+	assert.Equal(t, uint64(16000), snapshot.Misses)
+	assert.Equal(t, uint64(8000), snapshot.Removed(EVICTED))
+	assert.Equal(t, uint64(0), snapshot.Removed(EXPIRED))
+	assert.Equal(t, 8000*time.Millisecond, snapshot.TotalLoadTime)
+	assert.InDelta(t, 1.0/3, snapshot.HitRatio(), 1e-9)
+	assert.Equal(t, time.Millisecond, snapshot.AverageLoadPenalty())
+}
+
+func TestStats_Minus(t *testing.T) {
+	a := Stats{Hits: 10, Misses: 5, TotalLoadTime: time.Second}
+	a.Removals[EXPIRED] = 3
+	b := Stats{Hits: 4, Misses: 6, TotalLoadTime: 2 * time.Second}
+	b.Removals[EXPIRED] = 1
+
+	d := a.Minus(b)
+	assert.Equal(t, uint64(6), d.Hits)
+	assert.Equal(t, uint64(0), d.Misses)
+	assert.Equal(t, time.Duration(0), d.TotalLoadTime)
+	assert.Equal(t, uint64(2), d.Removed(EXPIRED))
+	assert.Equal(t, 1.0, Stats{}.HitRatio())
+}
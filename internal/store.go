@@ -1,7 +1,9 @@
 package internal
 
 import (
-	"runtime"
+	"context"
+	"errors"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,26 +21,62 @@ const (
 	REMOVED RemoveReason = iota
 	EVICTED
 	EXPIRED
+	// IDLE means the item was not accessed within the expire after access duration
+	IDLE
+	// CLOSED means the store was closed while the item was in it
+	CLOSED
 )
 
+// ErrClosed is returned by the operations of a closed store
+var ErrClosed = errors.New("wtlfu: cache closed")
+
+func (r RemoveReason) String() string {
+	switch r {
+	case REMOVED:
+		return "removed"
+	case EVICTED:
+		return "evicted"
+	case EXPIRED:
+		return "expired"
+	case IDLE:
+		return "idle"
+	case CLOSED:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Split is the weight given to the windows, the main cache and its protection segment,
+// and the hit rate of the last sample of the adaptive window
+type Split struct {
+	// Window is the total weight of the shard windows
+	Window int
+	// Main is the weight of the main cache, Protected the part of it kept by its protection segment
+	Main      int
+	Protected int
+	HitRate   float64
+}
+
 type Shard[K comparable, V any] struct {
 	dict       map[K]*Item[K, V]
 	cap        int
-	windowCap  int
 	window     *Lru[K, V]
 	doorkeeper *bloomFilter
 	dkCounter  int
 	mu         sync.RWMutex
 }
 
-func newShard[K comparable, V any](cap, windowCap int) *Shard[K, V] {
-	return &Shard[K, V]{
-		dict:       make(map[K]*Item[K, V], cap),
-		doorkeeper: newBloomFilter(20*cap, 0.01),
-		cap:        cap,
-		windowCap:  windowCap,
-		window:     NewLru[K, V](windowCap),
+// newShard returns a shard, its doorkeeper is nil when it does not filter writes
+func newShard[K comparable, V any](cap, windowCap, dkFactor int, dkFPR float64, dkMode DoorkeeperMode) *Shard[K, V] {
+	s := &Shard[K, V]{
+		dict:   make(map[K]*Item[K, V], cap),
+		cap:    cap,
+		window: NewLru[K, V](windowCap),
+	}
+	if dkMode == DoorkeeperAdmission {
+		s.doorkeeper = newBloomFilter(dkFactor*cap, dkFPR)
 	}
+	return s
 }
 
 func (s *Shard[K, V]) get(key K) (*Item[K, V], bool) {
@@ -65,56 +103,132 @@ func (s *Shard[K, V]) delete(i *Item[K, V]) bool {
 type Store[K comparable, V any] struct {
 	cap             int
 	shards          []*Shard[K, V]
-	hash            *HashKey[K]
+	hash            Hasher[K]
 	shardNum        int
 	policy          *TinyLFU[K, V]
 	timerWheel      *TimerWheel[K, V]
 	readBuf         *Queue[ReadBufItem[K, V]]
-	readCounter     *atomic.Uint32
+	readCounter     atomic.Uint32
 	writeBuf        chan WriteBufItem[K, V]
-	itemPoll        sync.Pool
 	mu              sync.Mutex
 	closed          bool
+	tickInterval    time.Duration
 	removalListener func(key K, value V, reason RemoveReason)
+	weigher         func(key K, value V) uint32
+	stats           StatsCounter
+
+	// closing rejects new writes once Close started, closed stops the timer wheel and
+	// the read buffer once the items are removed
+	closing atomic.Bool
+	// quit is closed by Close, stopped by maintenance once it and the ticker have exited
+	quit    chan struct{}
+	stopped chan struct{}
+	// clearOnce removes the items once the goroutines stopped, by the first Close to see it
+	clearOnce sync.Once
+	// writers counts the writes that passed the closing check, Close waits for their sends
+	// before quitting so none of them is dropped
+	writers sync.WaitGroup
+	// notifyOnClose calls the removal listener for the items left when the store is closed
+	notifyOnClose bool
+
+	// maxWeight is the total weight of the windows and the main cache
+	maxWeight int
+	// windowCap is the window weight of each shard, guarded by mu
+	windowCap int
+	// climber resizes the windows when the window is adaptive, guarded by mu
+	climber *hillClimber
+
+	// default ttl of entries set without one
+	expireAfterWrite time.Duration
+	// expiry computes the ttl of entries set without one, it excludes expireAfterWrite
+	expiry Expiry[K, V]
+
+	// refresh after write, refreshBuf is nil unless a LoadingStore enabled it
+	refreshAfter int64
+	refreshBuf   chan *Item[K, V]
 }
 
 func NewStore[K comparable, V any](cap int) *Store[K, V] {
-	hashKey := NewHash[K]()
-	writeBufSize := cap / 100
-	if writeBufSize < MinWriteBuffSize {
-		writeBufSize = MinWriteBuffSize
+	s, err := NewStoreWithConfig(Config[K, V]{Capacity: cap})
+	if err != nil {
+		panic(err)
 	}
-	if writeBufSize > MaxWriteBuffSize {
-		writeBufSize = MaxWriteBuffSize
+	return s
+}
+
+func NewStoreWithConfig[K comparable, V any](cfg Config[K, V]) (*Store[K, V], error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
-	shardNum := 1
-	for shardNum < runtime.NumCPU() {
-		shardNum *= 2
+	// the global source of math/rand is randomly seeded
+	seeds := rand.New(rand.NewSource(rand.Int63()))
+	if cfg.Seed != 0 {
+		seeds = rand.New(rand.NewSource(int64(cfg.Seed)))
 	}
-
-	shardSize := cap / shardNum
-	windowSize := cap / 100 / shardNum
-	if shardSize < 50 {
-		shardSize = 100
+	var hashKey Hasher[K]
+	if cfg.Hasher != nil {
+		hashKey = seededHasher[K]{cfg.Hasher, seeds.Uint64()}
+	} else {
+		h, err := NewHash[K](seeds.Uint64())
+		if err != nil {
+			return nil, err
+		}
+		hashKey = h
 	}
-	mainCacheSize := cap - windowSize*shardNum
 
 	s := &Store[K, V]{
-		cap:        cap,
-		shards:     make([]*Shard[K, V], 0, shardNum),
-		shardNum:   shardNum,
-		hash:       hashKey,
-		policy:     NewTinyLFU[K, V](mainCacheSize, hashKey),
-		readBuf:    NewQueue[ReadBufItem[K, V]](),
-		writeBuf:   make(chan WriteBufItem[K, V], writeBufSize),
-		itemPoll:   sync.Pool{New: func() interface{} { return &Item[K, V]{} }},
-		timerWheel: NewTimerWheel[K, V](uint(cap)),
+		cap:          cfg.Capacity,
+		shards:       make([]*Shard[K, V], 0, cfg.ShardCount),
+		shardNum:     cfg.ShardCount,
+		hash:         hashKey,
+		policy:       NewTinyLFU[K, V](cfg.mainCap(), cfg.Capacity, cfg.ProbationRatio, cfg.SampleSize, cfg.Sketch, cfg.ConservativeUpdate, hashKey, seeds),
+		readBuf:      NewQueue[ReadBufItem[K, V]](),
+		writeBuf:     make(chan WriteBufItem[K, V], cfg.WriteBufferSize),
+		timerWheel:   NewTimerWheel[K, V](uint(cfg.Capacity), cfg.ExpireAfterAccess, cfg.Clock),
+		tickInterval: cfg.TickInterval,
+		quit:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+
+		expireAfterWrite: cfg.ExpireAfterWrite,
+		expiry:           cfg.Expiry,
+
+		removalListener: cfg.RemovalListener,
+		notifyOnClose:   cfg.NotifyOnClose,
+		weigher:         cfg.Weigher,
+		stats:           cfg.StatsCounter,
+
+		maxWeight: cfg.maxWeight(),
+		windowCap: cfg.windowCap(),
+	}
+	if s.stats == nil {
+		s.stats = noopStats{}
+	}
+	switch cfg.DoorkeeperMode {
+	case DoorkeeperAdmission:
+		s.policy.onAge = s.resetDoorkeepers
+	case DoorkeeperFrequency:
+		s.policy.doorkeeper = newBloomFilter(cfg.DoorkeeperFactor*cfg.Capacity, cfg.DoorkeeperFPR)
+	}
+	if cfg.AdaptiveWindow {
+		s.climber = newHillClimber(cfg.Capacity, s.maxWeight)
 	}
 	for i := 0; i < s.shardNum; i++ {
-		s.shards = append(s.shards, newShard[K, V](shardSize, windowSize))
+		s.shards = append(s.shards, newShard[K, V](cfg.shardCap(), cfg.windowCap(), cfg.DoorkeeperFactor, cfg.DoorkeeperFPR, cfg.DoorkeeperMode))
 	}
 	go s.maintenance()
-	return s
+	return s, nil
+}
+
+// resetDoorkeepers clears the admission doorkeepers of the shards when the sketch ages,
+// so first writes are forgotten like frequencies. The caller must hold s.mu
+func (s *Store[K, V]) resetDoorkeepers() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		shard.doorkeeper.reset()
+		shard.dkCounter = 0
+		shard.mu.Unlock()
+	}
 }
 
 // spread hash before get index
@@ -134,35 +248,153 @@ func (s *Store[K, V]) drainRead() {
 		if !ok {
 			break
 		}
+		if s.closed {
+			// the items are gone, reads only need to leave the buffer
+			continue
+		}
+		if s.climber != nil {
+			s.climber.record(v.item != nil)
+		}
+		if v.item != nil && !s.inMainCache(v.item) {
+			// window items are ordered by their shard, only the frequency is recorded
+			s.policy.increment(v.hash)
+			continue
+		}
 		s.policy.Access(v)
 	}
+	if s.climber != nil && !s.closed {
+		if delta := s.climber.adjust(); delta != 0 {
+			s.resizeWindow(delta)
+		}
+	}
 	s.mu.Unlock()
 	s.readCounter.Store(0)
 }
 
+// resizeWindow moves delta weight from the main cache to the windows, or back if
+// delta is negative, split evenly between the shards. The caller must hold s.mu
+func (s *Store[K, V]) resizeWindow(delta int) {
+	step := delta / s.shardNum
+	if step == 0 {
+		step = 1
+		if delta < 0 {
+			step = -1
+		}
+	}
+	windowCap := s.windowCap + step
+	if maxCap := int(float64(s.maxWeight)*maxWindowRatio) / s.shardNum; windowCap > maxCap {
+		windowCap = maxCap
+	}
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	if windowCap == s.windowCap {
+		return
+	}
+	s.windowCap = windowCap
+
+	// the main cache is resized first, so it makes room before a shrinking window sends it candidates
+	for _, e := range s.policy.Resize(s.maxWeight - windowCap*s.shardNum) {
+		s.removeItem(e, EVICTED)
+	}
+	now := s.timerWheel.clock.nowNano()
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		expired, candidates := s.windowEvicted(shard, shard.window.Resize(windowCap), now)
+		shard.mu.Unlock()
+		s.notifyExpired(expired)
+		for _, item := range candidates {
+			if !s.alive(item) {
+				continue
+			}
+			s.admit(item)
+		}
+	}
+}
+
+// Split returns the current weight of each shard window, of the main cache and of its protection segment
+func (s *Store[K, V]) Split() Split {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	split := Split{
+		Window:    s.windowCap * s.shardNum,
+		Main:      s.policy.cap,
+		Protected: s.policy.mainCache.secondSegment.Cap(),
+	}
+	if s.climber != nil {
+		split.HitRate = s.climber.prevHitRate
+	}
+	return split
+}
+
 func (s *Store[K, V]) Get(key K) (V, bool) {
-	// tick，每次操作都会增加一个计数器
-	s.policy.counter.Add(1)
+	v, _, _, ok := s.get(key, 0)
+	s.recordRead(ok)
+	return v, ok
+}
+
+// recordRead records a hit or a miss, get does not so a retried read is counted once
+func (s *Store[K, V]) recordRead(hit bool) {
+	if hit {
+		s.stats.RecordHits(1)
+	} else {
+		s.stats.RecordMisses(1)
+	}
+}
+
+// Stats returns a snapshot of the counters of the store
+func (s *Store[K, V]) Stats() Stats {
+	return s.stats.Snapshot()
+}
 
+// get returns the value of key, an entry expired less than stale nanoseconds ago
+// is returned with isStale set. The returned item is only valid when ok is true
+func (s *Store[K, V]) get(key K, stale int64) (res V, item *Item[K, V], isStale bool, ok bool) {
+	if s.closing.Load() {
+		return res, nil, false, false
+	}
 	h, index := s.index(key)
 	shard := s.shards[index]
 	readCount := s.readCounter.Add(1)
 
+	var reSchedule bool
 	shard.mu.RLock()
-	defer shard.mu.RUnlock()
-
-	item, ok := shard.get(key)
-	var res V
+	item, ok = shard.get(key)
 	if ok {
+		now := s.timerWheel.clock.nowNano()
 		expire := item.expire.Load()
-		if expire != 0 && expire < s.timerWheel.clock.nowNano() {
+		isStale = expire != 0 && expire < now
+		if isStale && expire+stale < now {
 			// 如果这是一个DDL的缓存项目，并且已经过期，那么Get失败
 			ok = false
+		} else if s.idle(item, now) {
+			// idle items are never served as stale
+			ok = false
 		} else {
-			s.policy.hitCount.Add(1)
+			s.touch(item, now)
 			res = item.val
+			if !isStale {
+				reSchedule = s.expireAfterRead(item, expire, now)
+				s.maybeRefresh(item, now)
+			}
+		}
+	}
+	shard.mu.RUnlock()
+
+	if reSchedule {
+		// reads must not wait for write backpressure. Dropping the reschedule is safe:
+		// reads check the expiration, and the item is removed when its old bucket fires
+		select {
+		case s.writeBuf <- WriteBufItem[K, V]{
+			item:       item,
+			code:       UPDATE,
+			reSchedule: true,
+		}:
+		default:
 		}
 	}
+
+	// drainRead takes the store lock, which must never be acquired while holding a shard lock
 	switch {
 	case readCount < MaxReadBuffSize:
 		var send ReadBufItem[K, V]
@@ -174,120 +406,389 @@ func (s *Store[K, V]) Get(key K) (V, bool) {
 	case readCount == MaxReadBuffSize:
 		s.drainRead()
 	}
-	return res, ok
+	return res, item, isStale && ok, ok
 }
 
 func (s *Store[K, V]) Set(key K, val V, ttl time.Duration) bool {
-	s.policy.counter.Add(1)
+	r := s.set(key, val, ttl, false)
+	return r != SetRejected && r != SetClosed
+}
+
+// SetWithResult is Set reporting what happened to the write, force skips the doorkeeper
+func (s *Store[K, V]) SetWithResult(key K, val V, ttl time.Duration, force bool) SetResult {
+	return s.set(key, val, ttl, force)
+}
 
+// set inserts or updates key, force skips the doorkeeper so the value is always admitted to the window
+func (s *Store[K, V]) set(key K, val V, ttl time.Duration, force bool) SetResult {
 	h, index := s.index(key)
 	shard := s.shards[index]
 
-	var expire int64
-	if ttl > 0 {
-		// 计算到期时间
-		expire = s.timerWheel.clock.expireNano(ttl)
+	now := s.timerWheel.clock.nowNano()
+	if ttl <= 0 && s.expiry == nil {
+		ttl = s.expireAfterWrite
 	}
+	// 计算到期时间
+	expire := expireAt(now, ttl)
+	weight := s.weigh(key, val)
 
+	// writes to writeBuf must happen after the shard lock is released, otherwise
+	// maintenance may wait for the shard lock while we wait for a free buffer slot
 	shard.mu.Lock()
-	defer shard.mu.Unlock()
-
+	// checked under the shard lock, so no write is stored after Close cleared the shard
+	if !s.startWrite() {
+		shard.mu.Unlock()
+		return SetClosed
+	}
+	defer s.writers.Done()
 	item, ok := shard.get(key)
 	if ok {
 		// 如果存在，那么更新
-		var reScheduler bool
+		var reScheduler, reWeight bool
+		var expired, candidates []*Item[K, V]
 		item.val = val
-		if expire != 0 {
+		item.writeTime.Store(now)
+		s.touch(item, now)
+		if ttl <= 0 && s.expiry != nil {
+			// the expiry decides the expiration, even if it removes it
+			if old := item.expire.Load(); old != 0 && old < now {
+				// 已经过期的item被更新，相当于重新写入
+				expire = expireAt(now, s.expiry.ExpireAfterCreate(key, val))
+			} else {
+				expire = expireAt(now, s.expiry.ExpireAfterUpdate(key, val, remaining(old, now)))
+			}
+			if item.expire.Swap(expire) != expire {
+				reScheduler = true
+			}
+		} else if old := item.expire.Load(); old != 0 && old < now && expire == 0 {
+			// 已经过期的item被更新，相当于重新写入，不能沿用过期时间，需要从timeWheel中移除
+			item.expire.Store(0)
+			item.ttl.Store(0)
+			reScheduler = true
+		} else if expire != 0 {
 			// 原子操作，更新过期时间
+			item.ttl.Store(int64(ttl))
 			oldExpire := item.expire.Swap(expire)
 			// 如果过期时间不一样，那么需要重新调度
 			if oldExpire != expire {
 				reScheduler = true
 			}
 		}
-		if reScheduler {
-			s.writeBuf <- WriteBufItem[K, V]{
+		if weight != item.weight.Load() {
+			switch {
+			case item.inWindow && int(weight) > shard.window.Cap():
+				// the item no longer fits in the window, it goes straight to the policy
+				shard.window.Remove(item)
+				item.weight.Store(weight)
+				candidates = []*Item[K, V]{item}
+			case item.inWindow:
+				// window items are owned by the shard, the update may push items out of the window
+				expired, candidates = s.windowEvicted(shard, shard.window.UpdateWeight(item, weight), now)
+			default:
+				// main cache items are owned by maintenance
+				reWeight = true
+			}
+		}
+		shard.mu.Unlock()
+		s.notifyExpired(expired)
+		if reScheduler || reWeight {
+			s.send(WriteBufItem[K, V]{
 				item:       item,
 				code:       UPDATE,
-				reSchedule: true,
-			}
+				reSchedule: reScheduler,
+				reWeight:   reWeight,
+				weight:     weight,
+			})
 		}
-		return true
+		s.sendCandidates(candidates)
+		return SetUpdated
 	}
 	// 如果不存在，需要先加入window
-	// 非更新的set操作，需要判断是否触发保鲜机制
-	if shard.dkCounter >= shard.cap {
-		// 触发保险机制
-		shard.doorkeeper.reset()
-		shard.dkCounter = 0
-	}
+	if shard.doorkeeper != nil {
+		// 非更新的set操作，需要判断是否触发保鲜机制
+		if shard.dkCounter >= shard.cap {
+			// 触发保险机制
+			shard.doorkeeper.reset()
+			shard.dkCounter = 0
+		}
 
-	// 如果这是第一次插入，直接忽略，无法通过doorkeeper
-	hit := shard.doorkeeper.insert(h)
-	if !hit {
-		shard.dkCounter++
-		return false
+		// 如果这是第一次插入，直接忽略，无法通过doorkeeper
+		hit := shard.doorkeeper.insert(h)
+		if !hit {
+			shard.dkCounter++
+			if !force {
+				shard.mu.Unlock()
+				s.stats.RecordDoorkeeperRejection()
+				return SetRejected
+			}
+		}
 	}
 
 	// 如果通过了doorkeeper，那么就可以插入了
-	item = s.itemPoll.Get().(*Item[K, V])
-	item.key = key
-	item.val = val
-	item.expire.Store(expire)
+	if ttl <= 0 && s.expiry != nil {
+		expire = expireAt(now, s.expiry.ExpireAfterCreate(key, val))
+	}
+	// items are never reused, the read buffer may still point to removed items
+	item = NewItem(key, val, expire)
+	if expire != 0 && ttl > 0 {
+		item.ttl.Store(int64(ttl))
+	}
+	item.weight.Store(weight)
+	item.writeTime.Store(now)
+	s.touch(item, now)
 	item.shardNum = index
 	shard.set(item)
 
-	if evicted, isEvicted := shard.window.Add(item); isEvicted {
+	result := SetAdmitted
+	var expired, candidates []*Item[K, V]
+	if int(weight) > shard.window.Cap() {
+		// an item heavier than the whole window goes straight to the policy
+		result = SetCandidate
+		candidates = []*Item[K, V]{item}
+	} else {
 		// 如果window满了，那么需要将evicted的item从shard中删除并且尝试假如到policy中
-		expire := evicted.expire.Load()
-		if expire > 0 && expire < s.timerWheel.clock.nowNano() {
-			// 如果被window剔除的已经过期，那么直接删除，放回到poll
-			deleted := shard.delete(evicted)
-			if deleted {
-				s.postDelete(item)
-				if s.removalListener != nil {
-					s.removalListener(evicted.key, evicted.val, EXPIRED)
-				}
+		expired, candidates = s.windowEvicted(shard, shard.window.Add(item), now)
+	}
+	shard.mu.Unlock()
+
+	s.notifyExpired(expired)
+	if expire != 0 || s.timerWheel.idle > 0 {
+		// 即使还在window中，也需要加入timeWheel，这样过期后才能被及时清理
+		s.send(WriteBufItem[K, V]{
+			item:       item,
+			code:       UPDATE,
+			reSchedule: true,
+		})
+	}
+	s.sendCandidates(candidates)
+	return result
+}
+
+// reloadTTL returns the ttl a reloaded value of key is written with. Without a default
+// ttl it is the ttl of the entry it replaces, so revalidating an entry written with
+// SetWithTTL does not make it live forever
+func (s *Store[K, V]) reloadTTL(key K) time.Duration {
+	if s.expiry != nil || s.expireAfterWrite != 0 {
+		return 0
+	}
+	_, index := s.index(key)
+	shard := s.shards[index]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	if item, ok := shard.get(key); ok {
+		return time.Duration(item.ttl.Load())
+	}
+	return 0
+}
+
+// expireAfterRead applies the expiry read hook to a fresh item read under the shard lock,
+// and reports whether the timer wheel must reschedule the item. An extended expiration
+// is left to the timer wheel, which reschedules items that are not expired yet
+func (s *Store[K, V]) expireAfterRead(item *Item[K, V], expire, now int64) bool {
+	if s.expiry == nil {
+		return false
+	}
+	newExpire := expireAt(now, s.expiry.ExpireAfterRead(item.key, item.val, remaining(expire, now)))
+	if newExpire == expire {
+		return false
+	}
+	item.expire.Store(newExpire)
+	return newExpire != 0 && (expire == 0 || newExpire < expire)
+}
+
+// touch records an access of item at now when items expire after access
+func (s *Store[K, V]) touch(item *Item[K, V], now int64) {
+	if s.timerWheel.idle > 0 {
+		item.accessTime.Store(now)
+	}
+}
+
+// idle reports whether item has not been accessed within the expire after access duration
+func (s *Store[K, V]) idle(item *Item[K, V], now int64) bool {
+	return s.timerWheel.idle > 0 && item.accessTime.Load()+s.timerWheel.idle < now
+}
+
+// weigh returns the weight of an entry
+func (s *Store[K, V]) weigh(key K, val V) uint32 {
+	if s.weigher == nil {
+		return 1
+	}
+	return s.weigher(key, val)
+}
+
+// windowEvicted splits the items evicted from the window of shard into expired items,
+// which are deleted at once, and candidates for the main cache. The shard lock must be held
+func (s *Store[K, V]) windowEvicted(shard *Shard[K, V], evicted []*Item[K, V], now int64) (expired, candidates []*Item[K, V]) {
+	for _, item := range evicted {
+		if deadline, _ := s.timerWheel.deadline(item); deadline > 0 && deadline < now {
+			// 如果被window剔除的已经过期，那么直接删除，它可能还在timeWheel中，等到期时会被忽略
+			if shard.delete(item) {
+				expired = append(expired, item)
 			}
 		} else {
-			// 如果没有过期，那么需要尝试加入到policy中
-			s.writeBuf <- WriteBufItem[K, V]{
-				item: evicted,
-				code: NEW,
-			}
+			candidates = append(candidates, item)
+		}
+	}
+	return expired, candidates
+}
+
+// notifyExpired notifies the removal of items deleted by windowEvicted
+func (s *Store[K, V]) notifyExpired(expired []*Item[K, V]) {
+	for _, item := range expired {
+		_, reason := s.timerWheel.deadline(item)
+		s.notifyRemoval(item.key, item.val, reason)
+	}
+}
+
+// notifyRemoval records the removal of an entry and calls the removal listener
+func (s *Store[K, V]) notifyRemoval(key K, value V, reason RemoveReason) {
+	s.stats.RecordRemoval(reason)
+	if s.removalListener != nil {
+		s.removalListener(key, value, reason)
+	}
+}
+
+// admit tries to move a window candidate to the main cache, the caller must hold s.mu
+func (s *Store[K, V]) admit(item *Item[K, V]) {
+	for _, e := range s.policy.Set(item) {
+		if e == item {
+			s.stats.RecordAdmissionRejection()
 		}
+		s.removeItem(e, EVICTED)
+	}
+}
+
+// startWrite registers a write changing a shard, it must be called with the shard lock
+// held and returns false once the store is closing. A registered write calls
+// s.writers.Done once it sent its writes to maintenance
+func (s *Store[K, V]) startWrite() bool {
+	if s.closing.Load() {
+		return false
 	}
+	s.writers.Add(1)
 	return true
 }
 
+// send queues a write for maintenance, the caller must be registered by startWrite so
+// Close waits for it before quitting
+func (s *Store[K, V]) send(item WriteBufItem[K, V]) {
+	s.writeBuf <- item
+}
+
+// sendCandidates sends the items evicted from a window to the policy
+func (s *Store[K, V]) sendCandidates(candidates []*Item[K, V]) {
+	for _, item := range candidates {
+		// 如果没有过期，那么需要尝试加入到policy中
+		s.send(WriteBufItem[K, V]{
+			item: item,
+			code: NEW,
+		})
+	}
+}
+
 func (s *Store[K, V]) Delete(key K) {
 	_, index := s.index(key)
 	shard := s.shards[index]
 
 	shard.mu.Lock()
-	defer shard.mu.Unlock()
-
+	// a closing store leaves the item to Close, which notifies its removal
+	if !s.startWrite() {
+		shard.mu.Unlock()
+		return
+	}
+	defer s.writers.Done()
 	item, ok := shard.get(key)
 	if ok {
 		shard.delete(item)
-		s.writeBuf <- WriteBufItem[K, V]{
+		if item.inWindow {
+			shard.window.Remove(item)
+		}
+	}
+	shard.mu.Unlock()
+
+	if ok {
+		s.send(WriteBufItem[K, V]{
 			item: item,
 			code: REMOVE,
-		}
+		})
 	}
 }
 
-func (s *Store[K, V]) postDelete(item *Item[K, V]) {
-	var zero V
-	item.val = zero
-	s.itemPoll.Put(item)
+// enableRefresh turns on background refreshes, items older than after (0 disables
+// refresh after write) are sent to the returned channel by Get. It must be called
+// before the store is used
+func (s *Store[K, V]) enableRefresh(after time.Duration, bufSize int) <-chan *Item[K, V] {
+	s.refreshAfter = after.Nanoseconds()
+	s.refreshBuf = make(chan *Item[K, V], bufSize)
+	return s.refreshBuf
 }
 
-// remove item from cache/policy/timeWheel and add back to pool
-func (s *Store[K, V]) removeItem(item *Item[K, V], reason RemoveReason) {
-	if !item.isNew() {
-		s.policy.Remove(item)
+// enableStale keeps expired items for grace before removing them, so they can be served as stale.
+// It must be called before the store is used
+func (s *Store[K, V]) enableStale(grace time.Duration) {
+	s.mu.Lock()
+	s.timerWheel.grace = grace.Nanoseconds()
+	s.mu.Unlock()
+}
+
+// maybeRefresh queues item for a background refresh if it is older than refreshAfter
+func (s *Store[K, V]) maybeRefresh(item *Item[K, V], now int64) {
+	if s.refreshAfter == 0 || now-item.writeTime.Load() < s.refreshAfter {
+		return
+	}
+	s.queueRefresh(item)
+}
+
+// queueRefresh queues item for a background refresh. Only one refresh per item
+// is queued at a time, and nothing blocks if the queue is full
+func (s *Store[K, V]) queueRefresh(item *Item[K, V]) {
+	if s.refreshBuf == nil || !item.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	select {
+	case s.refreshBuf <- item:
+	default:
+		item.refreshing.Store(false)
+	}
+}
+
+// deleteItem deletes item like Delete, but only if it is still the entry stored under its key
+func (s *Store[K, V]) deleteItem(item *Item[K, V]) {
+	shard := s.shards[item.shardNum]
+
+	shard.mu.Lock()
+	if !s.startWrite() {
+		shard.mu.Unlock()
+		return
+	}
+	defer s.writers.Done()
+	ok := shard.delete(item)
+	if ok && item.inWindow {
+		shard.window.Remove(item)
 	}
+	shard.mu.Unlock()
+
+	if ok {
+		s.send(WriteBufItem[K, V]{
+			item: item,
+			code: REMOVE,
+		})
+	}
+}
+
+// Len returns the number of entries in the store, including expired entries not yet cleaned up
+func (s *Store[K, V]) Len() int {
+	total := 0
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		total += len(shard.dict)
+		shard.mu.RUnlock()
+	}
+	return total
+}
+
+// remove item from cache/policy/timeWheel
+func (s *Store[K, V]) removeItem(item *Item[K, V], reason RemoveReason) {
 	if !item.isNewWheel() {
 		s.timerWheel.deSchedule(item)
 	}
@@ -295,86 +796,233 @@ func (s *Store[K, V]) removeItem(item *Item[K, V], reason RemoveReason) {
 	var k K
 	var v V
 	switch reason {
-	case EVICTED, EXPIRED:
+	case EVICTED, EXPIRED, IDLE:
+		// window items are guarded by the shard lock, so check belong while holding it
 		shard := s.shards[item.shardNum]
 		shard.mu.Lock()
+		switch item.belong {
+		case ListWindow:
+			shard.window.Remove(item)
+		case ListProbation, ListProtection:
+			s.policy.Remove(item)
+		}
 		deleted := shard.delete(item)
 		shard.mu.Unlock()
 		if deleted {
 			k, v = item.key, item.val
-			if s.removalListener != nil {
-				s.removalListener(k, v, reason)
-			}
-			s.postDelete(item)
+			s.notifyRemoval(k, v, reason)
 		}
 	// already removed from shard map
 	case REMOVED:
+		if !item.isNew() {
+			s.policy.Remove(item)
+		}
 		shard := s.shards[item.shardNum]
 		shard.mu.RLock()
 		k, v = item.key, item.val
 		shard.mu.RUnlock()
-		if s.removalListener != nil {
-			s.removalListener(k, v, reason)
-		}
+		s.notifyRemoval(k, v, reason)
 	}
 }
 
+// inMainCache reports whether item is in the policy's main cache, the caller must hold s.mu.
+// The shard lock is needed because window items are moved by Set
+func (s *Store[K, V]) inMainCache(item *Item[K, V]) bool {
+	shard := s.shards[item.shardNum]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return item.belong == ListProbation || item.belong == ListProtection
+}
+
+// alive reports whether item is still the entry stored under its key
+func (s *Store[K, V]) alive(item *Item[K, V]) bool {
+	shard := s.shards[item.shardNum]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	exist, ok := shard.dict[item.key]
+	return ok && exist == item
+}
+
+// maintenance applies the writes of writeBuf to the policy and starts the ticker advancing
+// the timer wheel. Once Close quits it applies the writes already buffered, waits for
+// the ticker and closes stopped
 func (s *Store[K, V]) maintenance() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.tickInterval)
+	tickerDone := make(chan struct{})
 	go func() {
+		defer close(tickerDone)
 		for {
-			time.Sleep(500 * time.Millisecond)
-			s.mu.Lock()
-			if s.closed {
-				s.mu.Unlock()
+			select {
+			case <-ticker.C:
+				s.tick()
+			case <-s.quit:
 				return
 			}
-			s.timerWheel.advance(0, s.removeItem)
-			s.mu.Unlock()
 		}
 	}()
+	defer func() {
+		ticker.Stop()
+		<-tickerDone
+	}()
 
-	for writeItem := range s.writeBuf {
-		s.mu.Lock()
-		item := writeItem.item
-		if item == nil {
-			s.mu.Unlock()
-			continue
+	for {
+		select {
+		case writeItem := <-s.writeBuf:
+			s.write(writeItem)
+		case <-s.quit:
+			for {
+				select {
+				case writeItem := <-s.writeBuf:
+					s.write(writeItem)
+				default:
+					return
+				}
+			}
 		}
+	}
+}
 
-		// lock free because store API never read/modify item metadata
-		switch writeItem.code {
-		case NEW:
-			if item.expire.Load() != 0 {
-				s.timerWheel.schedule(item)
-			}
-			evicted := s.policy.Set(item)
-			if evicted != nil {
-				s.removeItem(evicted, EVICTED)
-			}
-			removed := s.policy.EvictEntries()
-			for _, e := range removed {
-				s.removeItem(e, EVICTED)
+// write applies a write of writeBuf to the policy
+func (s *Store[K, V]) write(writeItem WriteBufItem[K, V]) {
+	if writeItem.done != nil {
+		close(writeItem.done)
+		return
+	}
+	item := writeItem.item
+	if item == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// lock free because store API never read/modify item metadata
+	switch writeItem.code {
+	case NEW:
+		// the item may be deleted or replaced after it was evicted from window
+		if !s.alive(item) {
+			break
+		}
+		s.admit(item)
+	case REMOVE:
+		s.removeItem(item, REMOVED)
+	case UPDATE:
+		if writeItem.reWeight && s.alive(item) {
+			if s.inMainCache(item) {
+				for _, e := range s.policy.UpdateWeight(item, writeItem.weight) {
+					s.removeItem(e, EVICTED)
+				}
+			} else {
+				// a candidate not yet admitted, it is in no list
+				item.weight.Store(writeItem.weight)
 			}
-		case REMOVE:
-			s.removeItem(item, REMOVED)
-		case UPDATE:
-			if writeItem.reSchedule {
+		}
+		if writeItem.reSchedule && s.alive(item) {
+			if deadline, _ := s.timerWheel.deadline(item); deadline == 0 {
+				s.timerWheel.deSchedule(item)
+			} else {
 				s.timerWheel.schedule(item)
 			}
 		}
-		writeItem.item = nil
-		s.mu.Unlock()
 	}
 }
 
-func (s *Store[K, V]) Close() {
-	for _, shard := range s.shards {
-		shard.mu.RLock()
-		shard.dict = nil
-		shard.mu.RUnlock()
+// Tick removes the entries expired according to the clock now, instead of at the next
+// tick of the maintenance. The writes sent before the call are applied first, so the
+// entries they schedule are removed too
+func (s *Store[K, V]) Tick() {
+	if s.flushWrites() {
+		s.tick()
 	}
+}
+
+// Cleanup runs the pending maintenance before returning: it waits until the writes sent
+// before the call are processed, drains the read buffer and advances the timer wheel.
+// Without concurrent calls the state of the store is then deterministic. It does
+// nothing once the store is closed
+func (s *Store[K, V]) Cleanup() {
+	if !s.flushWrites() {
+		return
+	}
+	s.drainRead()
+	s.tick()
+}
+
+// flushWrites waits until maintenance processed the writes sent before the call, it
+// returns false if the store is closed first
+func (s *Store[K, V]) flushWrites() bool {
+	done := make(chan struct{})
+	select {
+	case s.writeBuf <- WriteBufItem[K, V]{done: done}:
+	case <-s.quit:
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-s.stopped:
+		// maintenance quit before reaching done
+		return false
+	}
+}
+
+// tick advances the timer wheel, it returns false once the store is closed
+func (s *Store[K, V]) tick() bool {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.timerWheel.advance(0, s.removeItem)
+	return true
+}
+
+// Close rejects new writes, applies the pending ones and waits for the background
+// goroutines to exit, then removes every item with the reason CLOSED. If ctx is done
+// first, Close returns its error and leaves the items, the goroutines still exit in the
+// background and a later Close finishes the job. Closing a closed store returns ErrClosed
+func (s *Store[K, V]) Close(ctx context.Context) error {
+	if s.closing.CompareAndSwap(false, true) {
+		// writes register under a shard lock, once every shard lock was taken no write
+		// registers anymore and writers can be waited for
+		for _, shard := range s.shards {
+			shard.mu.Lock()
+			shard.mu.Unlock()
+		}
+		go func() {
+			s.writers.Wait()
+			close(s.quit)
+		}()
+	}
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	err := ErrClosed
+	s.clearOnce.Do(func() {
+		s.clear()
+		err = nil
+	})
+	return err
+}
+
+// clear removes the items of a closed store, the removal listener is only called
+// for them if notifyOnClose is set
+func (s *Store[K, V]) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.closed = true
-	s.mu.Unlock()
-	close(s.writeBuf)
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		dict := shard.dict
+		shard.dict = nil
+		shard.mu.Unlock()
+		for key, item := range dict {
+			s.stats.RecordRemoval(CLOSED)
+			if s.notifyOnClose && s.removalListener != nil {
+				s.removalListener(key, item.val, CLOSED)
+			}
+		}
+	}
 }
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -8,7 +10,10 @@ import (
 )
 
 func TestDeqeExpiure(t *testing.T) {
-	store := NewStore[int, int](20000)
+	clock := NewFakeClock(time.Now())
+	store, err := NewStoreWithConfig(Config[int, int]{Capacity: 20000, Clock: clock})
+	require.Nil(t, err)
+	defer store.Close(context.Background())
 
 	expired := map[int]int{}
 	store.removalListener = func(key, value int, reason RemoveReason) {
@@ -16,16 +21,190 @@ func TestDeqeExpiure(t *testing.T) {
 			expired[key] = value
 		}
 	}
-	_, index := store.index(123)
+	h, index := store.index(123)
+	// the first write of a key is always rejected by doorkeeper
+	store.shards[index].doorkeeper.insert(h)
 	expire := store.timerWheel.clock.expireNano(200 * time.Millisecond)
-	for i := 0; i < 50; i++ {
-		entry := &Item[int, int]{key: i}
-		entry.expire.Store(expire)
+	for i := 0; i < store.shards[index].window.Cap(); i++ {
+		// negative keys never collide with the key set below
+		entry := NewItem(-i-1, 0, expire)
 		store.shards[index].window.Add(entry)
-		store.shards[index].dict[i] = entry
+		store.shards[index].dict[entry.key] = entry
 	}
 	require.True(t, len(expired) == 0)
-	time.Sleep(1 * time.Second)
+	clock.Advance(time.Second)
 	store.Set(123, 123, 1*time.Second)
 	require.True(t, len(expired) > 0)
 }
+
+func TestStoreCloseContext(t *testing.T) {
+	store, err := NewStoreWithConfig(Config[int, int]{Capacity: 100, NotifyOnClose: true})
+	require.Nil(t, err)
+	release := make(chan struct{})
+	closed := map[int]int{}
+	store.removalListener = func(key, value int, reason RemoveReason) {
+		switch reason {
+		case REMOVED:
+			<-release
+		case CLOSED:
+			closed[key] = value
+		}
+	}
+	store.SetWithResult(1, 1, 0, true)
+	store.SetWithResult(2, 2, 0, true)
+	// maintenance is blocked by the listener of the delete
+	store.Delete(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, store.Close(ctx), context.DeadlineExceeded)
+	require.Equal(t, SetClosed, store.SetWithResult(3, 3, 0, true))
+
+	// the items are left when Close gives up, the next Close removes them
+	require.Equal(t, 1, store.Len())
+	close(release)
+	require.Nil(t, store.Close(context.Background()))
+	require.Equal(t, 0, store.Len())
+	require.Equal(t, map[int]int{2: 2}, closed)
+	require.ErrorIs(t, store.Close(context.Background()), ErrClosed)
+}
+
+func TestStoreWeightedSketchSize(t *testing.T) {
+	// MaxWeight counts bytes, the sketch must still be sized for Capacity entries
+	cfg := Config[int, []byte]{
+		Capacity:  10000,
+		MaxWeight: 1 << 30,
+		Weigher:   func(key int, value []byte) uint32 { return uint32(len(value)) },
+	}
+	store, err := NewStoreWithConfig(cfg)
+	require.Nil(t, err)
+	defer store.Close(context.Background())
+	require.Equal(t, uint64(next2Power(10000)-1), store.policy.sketch.(*cmSketch).mask)
+
+	cfg.Sketch = SketchBlocked
+	blocked, err := NewStoreWithConfig(cfg)
+	require.Nil(t, err)
+	defer blocked.Close(context.Background())
+	require.Len(t, blocked.policy.sketch.(*blockedSketch).table, int(next2Power(10000)*cmDepth/16))
+}
+
+// shrinkingExpiry halves the remaining ttl of an entry on every read
+type shrinkingExpiry struct{}
+
+func (shrinkingExpiry) ExpireAfterCreate(key, value int) time.Duration { return time.Hour }
+
+func (shrinkingExpiry) ExpireAfterUpdate(key, value int, current time.Duration) time.Duration {
+	return current
+}
+
+func (shrinkingExpiry) ExpireAfterRead(key, value int, current time.Duration) time.Duration {
+	return current / 2
+}
+
+func TestStoreGetFullWriteBuffer(t *testing.T) {
+	store, err := NewStoreWithConfig(Config[int, int]{Capacity: 100, WriteBufferSize: MinWriteBuffSize, Expiry: shrinkingExpiry{}})
+	require.Nil(t, err)
+	defer store.Close(context.Background())
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	store.removalListener = func(key, value int, reason RemoveReason) {
+		if reason == REMOVED {
+			close(blocked)
+			<-release
+		}
+	}
+	store.SetWithResult(1, 1, 0, true)
+	store.SetWithResult(2, 2, 0, true)
+	store.Cleanup()
+
+	// maintenance is blocked by the listener of the delete, then the buffer is filled
+	store.Delete(2)
+	<-blocked
+	for len(store.writeBuf) < cap(store.writeBuf) {
+		store.writeBuf <- WriteBufItem[int, int]{}
+	}
+	done := make(chan bool)
+	go func() {
+		// shortening the expiration asks for a reschedule, which must not block the read
+		_, ok := store.Get(1)
+		done <- ok
+	}()
+	select {
+	case ok := <-done:
+		require.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("Get blocked on the write buffer")
+	}
+}
+
+func TestStoreDeleteRacingClose(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		store, err := NewStoreWithConfig(Config[int, int]{Capacity: 1000, NotifyOnClose: true})
+		require.Nil(t, err)
+		var mu sync.Mutex
+		removed := map[int]RemoveReason{}
+		store.removalListener = func(key, value int, reason RemoveReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := removed[key]; ok {
+				t.Errorf("key %d removed twice", key)
+			}
+			removed[key] = reason
+		}
+		for k := 0; k < 200; k++ {
+			store.SetWithResult(k, k, 0, true)
+		}
+		store.Cleanup()
+
+		// every entry is reported once, deleted or closed, whichever wins
+		var wg sync.WaitGroup
+		for k := 0; k < 200; k++ {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				store.Delete(k)
+			}(k)
+		}
+		require.Nil(t, store.Close(context.Background()))
+		wg.Wait()
+		mu.Lock()
+		require.Len(t, removed, 200)
+		mu.Unlock()
+	}
+}
+
+func TestStoreAgeResetsDoorkeepers(t *testing.T) {
+	store, err := NewStoreWithConfig(Config[int, int]{Capacity: 100})
+	require.Nil(t, err)
+	defer store.Close(context.Background())
+	require.Equal(t, SetRejected, store.SetWithResult(1, 1, 0, false))
+
+	// ageing forgets first writes as well, the next write of 1 is a first write again
+	store.mu.Lock()
+	store.policy.age()
+	store.mu.Unlock()
+	require.Equal(t, SetRejected, store.SetWithResult(1, 1, 0, false))
+	require.NotEqual(t, SetRejected, store.SetWithResult(1, 1, 0, false))
+}
+
+func TestStoreGetNoAllocs(t *testing.T) {
+	strs, err := NewStoreWithConfig(Config[string, int]{Capacity: 100})
+	require.Nil(t, err)
+	defer strs.Close(context.Background())
+	ints, err := NewStoreWithConfig(Config[int, int]{Capacity: 100})
+	require.Nil(t, err)
+	defer ints.Close(context.Background())
+	strs.SetWithResult("user:1234567890", 1, 0, true)
+	ints.SetWithResult(42, 1, 0, true)
+
+	// hits and misses, including the read buffer drains every 64 reads
+	require.Zero(t, testing.AllocsPerRun(1000, func() {
+		strs.Get("user:1234567890")
+		strs.Get("missing")
+	}))
+	require.Zero(t, testing.AllocsPerRun(1000, func() {
+		ints.Get(42)
+		ints.Get(7)
+	}))
+}
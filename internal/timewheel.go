@@ -2,18 +2,65 @@ package internal
 
 import (
 	"math/bits"
+	"sync"
 	"time"
 )
 
-type Clock struct {
-	start time.Time
+// Clock tells the time to a store, it must be safe for concurrent use
+type Clock interface {
+	Now() time.Time
 }
 
-func (c *Clock) nowNano() int64 {
-	return time.Since(c.start).Nanoseconds()
+// SystemClock is the default Clock, the time of the system
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a Clock that only moves when told to, so tests can expire entries
+// without sleeping
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Set moves the clock to now, which must not be before its current time
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.mu.Unlock()
+}
+
+// clock counts the nanoseconds elapsed on a Clock since the timer wheel was created
+type clock struct {
+	source Clock
+	start  time.Time
 }
 
-func (c *Clock) expireNano(ttl time.Duration) int64 {
+func (c *clock) nowNano() int64 {
+	return c.source.Now().Sub(c.start).Nanoseconds()
+}
+
+func (c *clock) expireNano(ttl time.Duration) int64 {
 	return c.nowNano() + ttl.Nanoseconds()
 }
 
@@ -22,12 +69,18 @@ type TimerWheel[K comparable, V any] struct {
 	spans   []int64
 	shift   []uint
 	wheel   [][]*List[K, V]
-	clock   *Clock
+	clock   *clock
 	nanos   int64
+	// grace keeps expired items this long before removing them, so they can be served as stale
+	grace int64
+	// idle expires items not accessed for this long, 0 disables it
+	idle int64
 }
 
-func NewTimerWheel[K comparable, V any](size uint) *TimerWheel[K, V] {
-	clock := &Clock{start: time.Now()}
+// NewTimerWheel creates a timer wheel reading the time of source, items not accessed for
+// idle are expired too unless it is 0
+func NewTimerWheel[K comparable, V any](size uint, idle time.Duration, source Clock) *TimerWheel[K, V] {
+	clock := &clock{source: source, start: source.Now()}
 	buckets := []uint{64, 64, 32, 4, 1}
 	spans := []int64{
 		next2Power((1 * time.Second).Nanoseconds()),
@@ -62,11 +115,16 @@ func NewTimerWheel[K comparable, V any](size uint) *TimerWheel[K, V] {
 		wheel:   wheel,
 		nanos:   clock.nowNano(),
 		clock:   clock,
+		idle:    idle.Nanoseconds(),
 	}
 
 }
 
 func (tw *TimerWheel[K, V]) findIndex(expire int64) (int, int) {
+	// a deadline already passed goes to the current bucket, not to one already processed
+	if expire < tw.nanos {
+		expire = tw.nanos
+	}
 	duration := expire - tw.nanos
 	for i := 0; i < 5; i++ {
 		if duration < tw.spans[i+1] {
@@ -78,19 +136,33 @@ func (tw *TimerWheel[K, V]) findIndex(expire int64) (int, int) {
 	return 4, 0
 }
 
+// deadline returns when item must be removed and why, 0 if it never expires.
+// Expired items are kept for grace, idle items are not
+func (tw *TimerWheel[K, V]) deadline(item *Item[K, V]) (int64, RemoveReason) {
+	expire := item.expire.Load()
+	if expire != 0 {
+		expire += tw.grace
+	}
+	if tw.idle > 0 {
+		if idle := item.accessTime.Load() + tw.idle; expire == 0 || idle < expire {
+			return idle, IDLE
+		}
+	}
+	return expire, EXPIRED
+}
+
 func (tw *TimerWheel[K, V]) deSchedule(item *Item[K, V]) {
-	item.Pre(ListTimeWheel).setNext(item.Next(ListTimeWheel), ListTimeWheel)
-	item.Next(ListTimeWheel).setPre(item.Pre(ListTimeWheel), ListTimeWheel)
-	item.setNext(nil, ListTimeWheel)
-	item.setPre(nil, ListTimeWheel)
+	if l := item._wheelList; l != nil {
+		l.remove(item)
+	}
 }
 
 func (tw *TimerWheel[K, V]) schedule(item *Item[K, V]) {
 	if !item.isNewWheel() {
 		tw.deSchedule(item)
 	}
-	item.belong = ListTimeWheel
-	x, y := tw.findIndex(item.expire.Load())
+	deadline, _ := tw.deadline(item)
+	x, y := tw.findIndex(deadline)
 	tw.wheel[x][y].PushFront(item)
 }
 
@@ -105,6 +177,10 @@ func (tw *TimerWheel[K, V]) advance(now int64, remove func(item *Item[K, V], rea
 		prevTicks := previous >> int64(tw.shift[i])
 		currentTicks := tw.nanos >> int64(tw.shift[i])
 		if currentTicks <= prevTicks {
+			if i == 0 {
+				// items scheduled since the last advance may be due in the current bucket
+				tw.expire(0, currentTicks, 0, remove)
+			}
 			break
 		}
 		tw.expire(i, prevTicks, currentTicks-prevTicks, remove)
@@ -113,9 +189,11 @@ func (tw *TimerWheel[K, V]) advance(now int64, remove func(item *Item[K, V], rea
 
 func (tw *TimerWheel[K, V]) expire(index int, prevTicks int64, delta int64, remove func(item *Item[K, V], reason RemoveReason)) {
 	mask := tw.buckets[index] - 1
+	// the bucket of the current tick is processed too, it holds the items expiring
+	// between its start and now, its items expiring later are rescheduled
 	steps := tw.buckets[index]
-	if delta < int64(steps) {
-		steps = uint(delta)
+	if delta+1 < int64(steps) {
+		steps = uint(delta + 1)
 	}
 	start := prevTicks & int64(mask)
 	end := start + int64(steps)
@@ -124,15 +202,17 @@ func (tw *TimerWheel[K, V]) expire(index int, prevTicks int64, delta int64, remo
 		item := list.Front()
 		for item != nil {
 			next := item.Next(ListTimeWheel)
-			if item.expire.Load() <= tw.nanos {
+			// reads only record the access time, an item whose deadline moved is rescheduled here
+			if deadline, reason := tw.deadline(item); deadline == 0 {
+				// the item does not expire anymore
+				tw.deSchedule(item)
+			} else if deadline <= tw.nanos {
 				tw.deSchedule(item)
-				remove(item, EXPIRED)
+				remove(item, reason)
 			} else {
 				tw.schedule(item)
 			}
 			item = next
-
 		}
-		list.Init()
 	}
 }
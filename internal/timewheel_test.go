@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimerWheel_Expire(t *testing.T) {
+	tw := NewTimerWheel[int, int](100, 100*time.Millisecond, SystemClock{})
+	now := tw.clock.nowNano()
+
+	// items sharing a bucket must all be removed
+	var items []*Item[int, int]
+	for i := 0; i < 3; i++ {
+		item := NewItem(i, i, now+int64(10*time.Millisecond))
+		tw.schedule(item)
+		items = append(items, item)
+	}
+	idle := NewItem(3, 3, 0)
+	idle.accessTime.Store(now)
+	tw.schedule(idle)
+	// accessed after scheduling, the wheel reschedules it
+	touched := NewItem(4, 4, 0)
+	touched.accessTime.Store(now)
+	tw.schedule(touched)
+	touched.accessTime.Store(now + int64(time.Minute))
+
+	removed := map[int]RemoveReason{}
+	tw.advance(now+int64(2*time.Second), func(item *Item[int, int], reason RemoveReason) {
+		removed[item.key] = reason
+	})
+	require.Equal(t, map[int]RemoveReason{0: EXPIRED, 1: EXPIRED, 2: EXPIRED, 3: IDLE}, removed)
+	require.False(t, touched.isNewWheel())
+}
+
+func TestTimerWheel_ExpireLevels(t *testing.T) {
+	for _, ttl := range []time.Duration{time.Second, 90 * time.Second, 2 * time.Hour, 30 * time.Hour} {
+		clock := NewFakeClock(time.Now())
+		tw := NewTimerWheel[int, int](1000, 0, clock)
+		now := tw.clock.nowNano()
+
+		// the entries of the bucket holding now must be removed too, not one span later
+		for i := 0; i < 500; i++ {
+			tw.schedule(NewItem(i, i, now+int64(ttl)+int64(i)*int64(time.Millisecond)))
+		}
+		for i := 500; i < 600; i++ {
+			tw.schedule(NewItem(i, i, now+3*int64(ttl)))
+		}
+		clock.Advance(ttl * 3 / 2)
+		removed := 0
+		tw.advance(0, func(item *Item[int, int], reason RemoveReason) {
+			require.Less(t, item.key, 500, "ttl %v", ttl)
+			removed++
+		})
+		require.Equal(t, 500, removed, "ttl %v", ttl)
+	}
+}
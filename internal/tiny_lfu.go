@@ -1,78 +1,162 @@
 package internal
 
-import (
-	"sync/atomic"
+import "math/rand"
+
+const (
+	// warmFrequency is the frequency from which a candidate that does not beat the
+	// victim may still be admitted, one time in warmAdmitChance
+	warmFrequency   = 6
+	warmAdmitChance = 128
 )
 
 type TinyLFU[K comparable, V any] struct {
 	cap       int
 	mainCache *SLru[K, V]
-	sketch    *cmSketch
-
-	hitCount atomic.Uint32
+	sketch    frequencySketch
+	// doorkeeper filters the sketch increments with DoorkeeperFrequency, nil otherwise
+	doorkeeper *bloomFilter
+	// samples counts the accesses, every sampleSize of them the sketch is halved
+	samples    int
+	sampleSize int
+	// duels remembers the last admission decisions for Explain
+	duels duelLog[K]
+	// onAge is called after every ageing, the store clears the admission doorkeepers with it
+	onAge func()
 
-	counter   atomic.Uint32
-	threshold uint32
-
-	hashKey *HashKey[K]
+	hashKey Hasher[K]
+	// rand breaks the duels of warm candidates, it is only used under the store lock
+	rand *rand.Rand
 }
 
-func NewTinyLFU[K comparable, V any](cap int, hashKey *HashKey[K]) *TinyLFU[K, V] {
+// NewTinyLFU returns a policy whose main cache holds a weight of cap. The sketch is sized
+// for entries keys, which is cap too unless the entries are weighed
+func NewTinyLFU[K comparable, V any](cap, entries int, probationRatio float64, sampleSize int, sketch SketchKind, conservative bool, hashKey Hasher[K], seeds *rand.Rand) *TinyLFU[K, V] {
 	return &TinyLFU[K, V]{
-		cap:       cap,
-		mainCache: newSLru[K, V](cap),
-		sketch:    newCmSketch(int64(cap)),
-		hashKey:   hashKey,
+		cap:        cap,
+		mainCache:  newSLru[K, V](cap, probationRatio),
+		sketch:     newFrequencySketch(sketch, int64(entries), conservative, seeds),
+		sampleSize: sampleSize,
+		hashKey:    hashKey,
+		rand:       rand.New(rand.NewSource(seeds.Int63())),
 	}
 }
 
-func (t *TinyLFU[K, V]) Set(i *Item[K, V]) *Item[K, V] {
+// Set tries to admit a new item into the main cache, and returns the evicted items,
+// which is i itself if it loses against the victim or is heavier than the main cache
+func (t *TinyLFU[K, V]) Set(i *Item[K, V]) []*Item[K, V] {
 	// if it is a new item, add it to the main cache
-	if i.isNew() {
-		if victim := t.mainCache.maybeVictim(); victim != nil {
-			freq := t.sketch.estimate(t.hashKey.Hash(i.key))
-			victimFreq := t.sketch.estimate(t.hashKey.Hash(victim.key))
-			if freq <= victimFreq {
+	if !i.isNew() {
+		return nil
+	}
+	d := duel[K]{key: i.key}
+	if int(i.weight.Load()) > t.cap {
+		t.duels.add(d)
+		return []*Item[K, V]{i}
+	}
+	if t.mainCache.weight()+int(i.weight.Load()) > t.cap {
+		if victim := t.mainCache.victim(nil); victim != nil {
+			d.hasVictim, d.victim = true, victim.key
+			d.freq = t.estimate(t.hashKey.Hash(i.key))
+			d.victimFreq = t.estimate(t.hashKey.Hash(victim.key))
+			if d.freq <= d.victimFreq {
 				// 如果从Window淘汰的freq还不如mainCache淘汰的，直接返回
-				return i
+				if !t.admitWarm(d.freq) {
+					t.duels.add(d)
+					return []*Item[K, V]{i}
+				}
+				d.random = true
 			}
 		}
-		evicted := t.mainCache.add(i)
-		return evicted
 	}
-	return nil
+	d.admitted = true
+	t.duels.add(d)
+	t.mainCache.add(i)
+	return t.evict(i)
+}
+
+// admitWarm randomly admits a warm candidate that lost its duel. Without it an attacker
+// raising the frequency of a victim, by reading keys colliding with it in the sketch,
+// could keep it in the main cache forever and reject every candidate; and candidates
+// as warm as the victim would never win a tie
+func (t *TinyLFU[K, V]) admitWarm(freq int64) bool {
+	return freq >= warmFrequency && t.rand.Intn(warmAdmitChance) == 0
 }
 
-// Access accesses an item in main cache
+// Access records a read in the sketch, a miss too so a key gains frequency before it
+// is written, and accesses the item if it is in the main cache
 func (t *TinyLFU[K, V]) Access(ri ReadBufItem[K, V]) {
-	if item := ri.item; item != nil {
-		t.sketch.increment(ri.hash)
-		if !item.isNew() {
-			t.mainCache.access(item)
-		}
+	t.increment(ri.hash)
+	if item := ri.item; item != nil && !item.isNew() {
+		t.mainCache.access(item)
+	}
+}
+
+// increment records an access of h, the first access only reaches the doorkeeper when there is one
+func (t *TinyLFU[K, V]) increment(h uint64) {
+	t.samples++
+	if t.samples >= t.sampleSize {
+		t.age()
+	}
+	if t.doorkeeper != nil && !t.doorkeeper.insert(h) {
+		return
+	}
+	t.sketch.increment(h)
+}
+
+// age is the freshness mechanism of TinyLFU: it halves every counter and clears the
+// doorkeepers, so the frequencies of keys no longer accessed fade. The sample count is
+// halved as the sum of the counters is
+func (t *TinyLFU[K, V]) age() {
+	t.sketch.reset()
+	t.doorkeeper.reset()
+	t.samples /= 2
+	if t.onAge != nil {
+		t.onAge()
 	}
 }
 
+// estimate returns the frequency of h, counting the access kept by the doorkeeper
+func (t *TinyLFU[K, V]) estimate(h uint64) int64 {
+	freq := t.sketch.estimate(h)
+	if t.doorkeeper != nil && t.doorkeeper.contains(h) {
+		freq++
+	}
+	return freq
+}
+
 // Remove removes an item from main cache
 func (t *TinyLFU[K, V]) Remove(i *Item[K, V]) {
 	t.mainCache.remove(i)
 }
 
+// UpdateWeight changes the weight of an item in the main cache and returns the evicted items
+func (t *TinyLFU[K, V]) UpdateWeight(i *Item[K, V], weight uint32) []*Item[K, V] {
+	t.mainCache.updateWeight(i, weight)
+	return t.EvictEntries()
+}
+
+// Resize changes the weight of the main cache and returns the items evicted to fit in it
+func (t *TinyLFU[K, V]) Resize(cap int) []*Item[K, V] {
+	t.cap = cap
+	t.mainCache.resize(cap)
+	return t.EvictEntries()
+}
+
+// EvictEntries evicts items until the main cache fits in its weight
 func (t *TinyLFU[K, V]) EvictEntries() []*Item[K, V] {
-	var removed []*Item[K, V]
+	return t.evict(nil)
+}
 
-	for t.mainCache.firstSegment.Len()+t.mainCache.secondSegment.Len() > t.mainCache.cap {
-		entry := t.mainCache.firstSegment.PopBack()
-		if entry == nil {
-			break
-		}
-		removed = append(removed, entry)
-	}
-	for t.mainCache.firstSegment.Len()+t.mainCache.secondSegment.Len() > t.mainCache.cap {
-		entry := t.mainCache.secondSegment.PopBack()
+// evict evicts from the back of probation, then protection, until the main cache
+// fits in its weight. keep is evicted last
+func (t *TinyLFU[K, V]) evict(keep *Item[K, V]) []*Item[K, V] {
+	var removed []*Item[K, V]
+	for t.mainCache.weight() > t.cap {
+		entry := t.mainCache.victim(keep)
 		if entry == nil {
 			break
 		}
+		t.mainCache.remove(entry)
 		removed = append(removed, entry)
 	}
 	return removed
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTinyLFU_DoorkeeperFrequency(t *testing.T) {
+	store, err := NewStoreWithConfig(Config[int, int]{Capacity: 100, DoorkeeperMode: DoorkeeperFrequency})
+	require.Nil(t, err)
+	defer store.Close(context.Background())
+	for _, shard := range store.shards {
+		assert.Nil(t, shard.doorkeeper)
+	}
+
+	policy := store.policy
+	h := store.hash.Hash(1)
+	policy.increment(h)
+	assert.Equal(t, int64(0), policy.sketch.estimate(h), "the first access only reaches the doorkeeper")
+	assert.Equal(t, int64(1), policy.estimate(h))
+	policy.increment(h)
+	assert.Equal(t, int64(1), policy.sketch.estimate(h))
+	assert.Equal(t, int64(2), policy.estimate(h))
+
+	// aging clears the doorkeeper and halves the sketch
+	policy.age()
+	assert.Equal(t, int64(0), policy.estimate(h))
+}
+
+func TestTinyLFU_Aging(t *testing.T) {
+	policy := NewTinyLFU[int, int](100, 100, DefaultProbationRatio, 1000, SketchRows, false, mustHash[int](), testSeeds())
+	old, hot := policy.hashKey.Hash(1), policy.hashKey.Hash(2)
+	for i := 0; i < 100; i++ {
+		policy.increment(old)
+	}
+	assert.Equal(t, int64(15), policy.estimate(old), "counters saturate at 15")
+
+	// the popularity shifts to another key, once old stops being accessed its
+	// frequency has to fall below the one of the new hot key
+	for i := 0; i < 3000; i++ {
+		policy.increment(hot)
+	}
+	assert.Greater(t, policy.estimate(hot), policy.estimate(old))
+	assert.Less(t, policy.estimate(old), int64(2))
+	assert.Less(t, policy.samples, policy.sampleSize)
+}
+
+func TestTinyLFU_NoAgingBeforeSampleSize(t *testing.T) {
+	policy := NewTinyLFU[int, int](100, 100, DefaultProbationRatio, 1000, SketchRows, false, mustHash[int](), testSeeds())
+	h := policy.hashKey.Hash(1)
+	for i := 0; i < 10; i++ {
+		policy.increment(h)
+	}
+	other := policy.hashKey.Hash(2)
+	for i := 0; i < 989; i++ {
+		policy.increment(other)
+	}
+	assert.Equal(t, int64(10), policy.estimate(h))
+	policy.increment(other)
+	assert.Equal(t, int64(5), policy.estimate(h))
+}
+
+// fillPolicy admits keys 0 to n-1 into an empty main cache, key 0 ends up as the victim
+func fillPolicy(policy *TinyLFU[int, int], n int) {
+	for k := 0; k < n; k++ {
+		policy.Set(NewItem(k, k, 0))
+	}
+}
+
+func TestTinyLFU_HashFloodingAttack(t *testing.T) {
+	policy := NewTinyLFU[int, int](10, 10, DefaultProbationRatio, 1<<30, SketchRows, false, mustHash[int](), testSeeds())
+	fillPolicy(policy, 10)
+
+	// the attacker raises the frequency of the junk victim, as reads of keys colliding
+	// with it in the sketch would, without ever reading it so it stays the victim
+	junk := policy.hashKey.Hash(0)
+	for i := 0; i < 15; i++ {
+		policy.sketch.increment(junk)
+	}
+
+	// warm candidates never beat the junk, one is still admitted by chance after
+	// about warmAdmitChance tries and evicts it
+	tries := 0
+	for k := 100; k < 3000; k++ {
+		tries++
+		h := policy.hashKey.Hash(k)
+		for i := 0; i < warmFrequency; i++ {
+			policy.sketch.increment(h)
+		}
+		evicted := policy.Set(NewItem(k, k, 0))
+		require.Len(t, evicted, 1)
+		if evicted[0].key == k {
+			continue
+		}
+		assert.Equal(t, 0, evicted[0].key)
+		d, ok := policy.duels.last(k)
+		assert.True(t, ok)
+		assert.True(t, d.admitted)
+		assert.True(t, d.random)
+		break
+	}
+	assert.Less(t, tries, 2900, "the junk was never evicted")
+}
+
+func TestTinyLFU_ColdCandidateTie(t *testing.T) {
+	policy := NewTinyLFU[int, int](10, 10, DefaultProbationRatio, 1<<30, SketchRows, false, mustHash[int](), testSeeds())
+	fillPolicy(policy, 10)
+	for k := 100; k < 3000; k++ {
+		// cold candidates as frequent as the victim are always rejected
+		evicted := policy.Set(NewItem(k, k, 0))
+		assert.Len(t, evicted, 1)
+		assert.Equal(t, k, evicted[0].key)
+	}
+}
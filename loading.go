@@ -0,0 +1,147 @@
+package wtlfu
+
+import (
+	"context"
+	"time"
+
+	"wtlfu/internal"
+)
+
+// ErrNotFound is returned by GetOrLoad when the key was being loaded by a
+// BulkLoader that left it out of its result.
+var ErrNotFound = internal.ErrNotFound
+
+// Loader loads the value of a key missing from a LoadingCache.
+type Loader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// BulkLoader loads the values of several keys missing from a LoadingCache at
+// once. Keys it cannot find are left out of the returned map.
+type BulkLoader[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// RefreshFailurePolicy decides what happens to an entry whose background
+// refresh failed.
+type RefreshFailurePolicy = internal.RefreshFailurePolicy
+
+const (
+	// RefreshKeep keeps serving the old value, the refresh is retried by the
+	// first read at least RefreshAfterWrite after the failure.
+	RefreshKeep = internal.RefreshKeep
+	// RefreshLog is like RefreshKeep but also logs the error with the log package.
+	RefreshLog = internal.RefreshLog
+	// RefreshEvict removes the entry, the next GetOrLoad loads it again.
+	RefreshEvict = internal.RefreshEvict
+)
+
+// LoadingBuilder collects the options of a LoadingCache, see Builder.Loading.
+type LoadingBuilder[K comparable, V any] struct {
+	*Builder[K, V]
+	cfg internal.LoadingConfig[K, V]
+}
+
+// Loading turns the builder into a LoadingBuilder whose caches load missing
+// keys with loader. Cache options must be set before calling Loading.
+func (b *Builder[K, V]) Loading(loader Loader[K, V]) *LoadingBuilder[K, V] {
+	return &LoadingBuilder[K, V]{
+		Builder: b,
+		cfg:     internal.LoadingConfig[K, V]{Loader: internal.Loader[K, V](loader)},
+	}
+}
+
+// BulkLoader sets the loader used by GetAll for the keys it misses. Without
+// it GetAll loads each missing key with the Loader.
+func (b *LoadingBuilder[K, V]) BulkLoader(loader BulkLoader[K, V]) *LoadingBuilder[K, V] {
+	b.cfg.BulkLoader = internal.BulkLoader[K, V](loader)
+	return b
+}
+
+// RefreshAfterWrite makes a read of an entry written more than d ago reload
+// it in the background, while the old value keeps being served until the new
+// one is stored. Concurrent reads trigger a single refresh, which is run by a
+// small pool of workers rather than one goroutine per key.
+func (b *LoadingBuilder[K, V]) RefreshAfterWrite(d time.Duration) *LoadingBuilder[K, V] {
+	b.cfg.RefreshAfterWrite = d
+	return b
+}
+
+// StaleWhileRevalidate lets GetOrLoad and GetOrLoadStale return an entry that
+// expired less than d ago, while a single background refresh reloads it.
+// Entries need a ttl, see Builder.ExpireAfterWrite. Without ExpireAfterWrite or
+// an Expiry, a reloaded entry keeps the ttl it was given by SetWithTTL.
+func (b *LoadingBuilder[K, V]) StaleWhileRevalidate(d time.Duration) *LoadingBuilder[K, V] {
+	b.cfg.StaleWhileRevalidate = d
+	return b
+}
+
+// StaleIfError lets GetOrLoad and GetOrLoadStale return an entry that expired
+// less than d ago instead of the error of the loader that tried to reload it.
+// Entries need a ttl, see Builder.ExpireAfterWrite.
+func (b *LoadingBuilder[K, V]) StaleIfError(d time.Duration) *LoadingBuilder[K, V] {
+	b.cfg.StaleIfError = d
+	return b
+}
+
+// RefreshFailure sets what happens when a background refresh fails.
+// Default is RefreshKeep.
+func (b *LoadingBuilder[K, V]) RefreshFailure(policy RefreshFailurePolicy) *LoadingBuilder[K, V] {
+	b.cfg.RefreshFailure = policy
+	return b
+}
+
+// Build validates the options and creates the loading cache.
+func (b *LoadingBuilder[K, V]) Build() (*LoadingCache[K, V], error) {
+	if err := b.cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cache, err := b.Builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	store, err := internal.NewLoadingStore(cache.store, b.cfg)
+	if err != nil {
+		cache.Close(context.Background())
+		return nil, err
+	}
+	return &LoadingCache[K, V]{Cache: cache, store: store}, nil
+}
+
+// LoadingCache is a Cache that loads missing keys on GetOrLoad.
+type LoadingCache[K comparable, V any] struct {
+	*Cache[K, V]
+	store *internal.LoadingStore[K, V]
+}
+
+// GetOrLoad returns the value of key, loading it on a miss.
+//
+// Concurrent misses of the same key share one call to the loader, which runs
+// with the ctx of the caller that started it; other callers stop waiting when
+// their own ctx is done. A loaded value is always stored, bypassing the
+// doorkeeper. Loader errors are returned to every waiting caller and are not
+// cached, so the next call loads again.
+func (c *LoadingCache[K, V]) GetOrLoad(ctx context.Context, key K) (V, error) {
+	return c.store.GetOrLoad(ctx, key)
+}
+
+// GetOrLoadStale is like GetOrLoad, but stale reports that the returned value
+// has expired and is served because of StaleWhileRevalidate or StaleIfError.
+func (c *LoadingCache[K, V]) GetOrLoadStale(ctx context.Context, key K) (value V, stale bool, err error) {
+	return c.store.GetOrLoadStale(ctx, key)
+}
+
+// Close closes the cache like Cache.Close, then stops the refresh workers and
+// waits for the refreshes in flight, as long as ctx is not done. GetOrLoad,
+// GetOrLoadStale and GetAll return ErrClosed afterwards, and so do the calls
+// whose load was still running.
+func (c *LoadingCache[K, V]) Close(ctx context.Context) error {
+	return c.store.Close(ctx)
+}
+
+// GetAll returns the values of keys as a map.
+//
+// Hits are served from the cache and count as reads for the admission policy
+// like Get. Keys another goroutine is already loading are waited for, and all
+// remaining misses are passed to the BulkLoader in one call; their values are
+// always stored. Keys the BulkLoader did not return are left out of the map.
+// If any load fails, GetAll returns the error and a nil map.
+func (c *LoadingCache[K, V]) GetAll(ctx context.Context, keys []K) (map[K]V, error) {
+	return c.store.GetAll(ctx, keys)
+}
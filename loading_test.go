@@ -0,0 +1,501 @@
+package wtlfu_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"wtlfu"
+)
+
+func TestLoadingBuild_NilLoader(t *testing.T) {
+	_, err := wtlfu.NewBuilder[string, int](100).Loading(nil).Build()
+	var cfgErr *wtlfu.ConfigError
+	require.ErrorAs(t, err, &cfgErr)
+	require.Equal(t, "Loader", cfgErr.Field)
+}
+
+func TestLoadingCache_GetOrLoad(t *testing.T) {
+	var loads atomic.Int32
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			loads.Add(1)
+			return strconv.Atoi(key)
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	v, err := cache.GetOrLoad(context.Background(), "42")
+	require.Nil(t, err)
+	require.Equal(t, 42, v)
+
+	// the first load is admitted even though the doorkeeper has never seen the key
+	v, ok := cache.Get("42")
+	require.True(t, ok)
+	require.Equal(t, 42, v)
+
+	v, err = cache.GetOrLoad(context.Background(), "42")
+	require.Nil(t, err)
+	require.Equal(t, 42, v)
+	require.Equal(t, int32(1), loads.Load())
+}
+
+func TestLoadingCache_Coalescing(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			loads.Add(1)
+			<-release
+			return 1, nil
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := cache.GetOrLoad(context.Background(), "foo")
+			require.Nil(t, err)
+			require.Equal(t, 1, v)
+		}()
+	}
+	require.Eventually(t, func() bool { return loads.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.Equal(t, int32(1), loads.Load())
+}
+
+func TestLoadingCache_ErrorNotCached(t *testing.T) {
+	errBackend := errors.New("backend down")
+	var loads atomic.Int32
+	release := make(chan struct{})
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			if loads.Add(1) == 1 {
+				<-release
+				return 0, errBackend
+			}
+			return 1, nil
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.GetOrLoad(context.Background(), "foo")
+			require.ErrorIs(t, err, errBackend)
+		}()
+	}
+	require.Eventually(t, func() bool { return loads.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	_, ok := cache.Get("foo")
+	require.False(t, ok)
+	v, err := cache.GetOrLoad(context.Background(), "foo")
+	require.Nil(t, err)
+	require.Equal(t, 1, v)
+	require.Equal(t, int32(2), loads.Load())
+}
+
+func TestLoadingCache_WaiterContext(t *testing.T) {
+	release := make(chan struct{})
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			<-release
+			return 1, nil
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		v, err := cache.GetOrLoad(context.Background(), "foo")
+		require.Nil(t, err)
+		require.Equal(t, 1, v)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = cache.GetOrLoad(ctx, "foo")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	<-leaderDone
+}
+
+func TestLoadingCache_GetAll(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	cache, err := wtlfu.NewBuilder[int, int](100).
+		Loading(func(ctx context.Context, key int) (int, error) {
+			return key * 10, nil
+		}).
+		BulkLoader(func(ctx context.Context, keys []int) (map[int]int, error) {
+			mu.Lock()
+			batches = append(batches, keys)
+			mu.Unlock()
+			result := make(map[int]int, len(keys))
+			for _, k := range keys {
+				// odd keys do not exist in the backend
+				if k%2 == 0 {
+					result[k] = k * 10
+				}
+			}
+			return result, nil
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	v, err := cache.GetOrLoad(context.Background(), 2)
+	require.Nil(t, err)
+	require.Equal(t, 20, v)
+
+	result, err := cache.GetAll(context.Background(), []int{1, 2, 3, 4, 4})
+	require.Nil(t, err)
+	require.Equal(t, map[int]int{2: 20, 4: 40}, result)
+	// the hit on 2 and the duplicated 4 are not sent to the bulk loader
+	require.Equal(t, [][]int{{1, 3, 4}}, batches)
+
+	v, ok := cache.Get(4)
+	require.True(t, ok)
+	require.Equal(t, 40, v)
+	_, ok = cache.Get(3)
+	require.False(t, ok)
+}
+
+func TestLoadingCache_GetAllCoalescing(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	cache, err := wtlfu.NewBuilder[int, int](100).
+		Loading(func(ctx context.Context, key int) (int, error) {
+			loads.Add(1)
+			<-release
+			return key, nil
+		}).
+		BulkLoader(func(ctx context.Context, keys []int) (map[int]int, error) {
+			require.Equal(t, []int{2}, keys)
+			return map[int]int{2: 2}, nil
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = cache.GetOrLoad(context.Background(), 1)
+	}()
+	require.Eventually(t, func() bool { return loads.Load() == 1 }, time.Second, time.Millisecond)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	// 1 is in flight, so only 2 goes to the bulk loader and 1 is waited for
+	result, err := cache.GetAll(context.Background(), []int{1, 2})
+	require.Nil(t, err)
+	require.Equal(t, map[int]int{1: 1, 2: 2}, result)
+	<-done
+	require.Equal(t, int32(1), loads.Load())
+}
+
+func TestLoadingCache_GetAllError(t *testing.T) {
+	errBackend := errors.New("backend down")
+	cache, err := wtlfu.NewBuilder[int, int](100).
+		Loading(func(ctx context.Context, key int) (int, error) {
+			if key == 3 {
+				return 0, errBackend
+			}
+			return key, nil
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	// without a bulk loader, keys are loaded one by one
+	_, err = cache.GetAll(context.Background(), []int{1, 2, 3})
+	require.ErrorIs(t, err, errBackend)
+	v, ok := cache.Get(2)
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+}
+
+func TestLoadingCache_RefreshAfterWrite(t *testing.T) {
+	var version atomic.Int32
+	release := make(chan struct{}, 1)
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			if v := version.Add(1); v > 1 {
+				<-release
+				return int(v), nil
+			}
+			return 1, nil
+		}).
+		RefreshAfterWrite(20 * time.Millisecond).
+		Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	v, err := cache.GetOrLoad(context.Background(), "foo")
+	require.Nil(t, err)
+	require.Equal(t, 1, v)
+
+	time.Sleep(30 * time.Millisecond)
+	// the stale value is served while a single refresh is running
+	for i := 0; i < 10; i++ {
+		v, ok := cache.Get("foo")
+		require.True(t, ok)
+		require.Equal(t, 1, v)
+	}
+	require.Eventually(t, func() bool { return version.Load() == 2 }, time.Second, time.Millisecond)
+	release <- struct{}{}
+
+	require.Eventually(t, func() bool {
+		v, _ := cache.Get("foo")
+		return v == 2
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int32(2), version.Load())
+}
+
+func TestLoadingCache_RefreshFailure(t *testing.T) {
+	errBackend := errors.New("backend down")
+	for _, c := range []struct {
+		policy  wtlfu.RefreshFailurePolicy
+		evicted bool
+	}{
+		{wtlfu.RefreshKeep, false},
+		{wtlfu.RefreshEvict, true},
+	} {
+		var loads atomic.Int32
+		cache, err := wtlfu.NewBuilder[string, int](100).
+			Loading(func(ctx context.Context, key string) (int, error) {
+				if loads.Add(1) > 1 {
+					return 0, errBackend
+				}
+				return 1, nil
+			}).
+			RefreshAfterWrite(10 * time.Millisecond).
+			RefreshFailure(c.policy).
+			Build()
+		require.Nil(t, err)
+
+		_, err = cache.GetOrLoad(context.Background(), "foo")
+		require.Nil(t, err)
+		time.Sleep(20 * time.Millisecond)
+		cache.Get("foo")
+		require.Eventually(t, func() bool { return loads.Load() >= 2 }, time.Second, time.Millisecond)
+
+		if c.evicted {
+			require.Eventually(t, func() bool {
+				_, ok := cache.Get("foo")
+				return !ok
+			}, time.Second, time.Millisecond)
+		} else {
+			time.Sleep(10 * time.Millisecond)
+			v, ok := cache.Get("foo")
+			require.True(t, ok)
+			require.Equal(t, 1, v)
+		}
+		cache.Close(context.Background())
+	}
+}
+
+func TestLoadingCache_RefreshKeepRetry(t *testing.T) {
+	errBackend := errors.New("backend down")
+	var loads atomic.Int32
+	clock := wtlfu.NewFakeClock(time.Now())
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Clock(clock).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			if loads.Add(1) > 1 {
+				return 0, errBackend
+			}
+			return 1, nil
+		}).
+		RefreshAfterWrite(time.Minute).
+		Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	_, err = cache.GetOrLoad(context.Background(), "foo")
+	require.Nil(t, err)
+	clock.Advance(2 * time.Minute)
+	cache.Get("foo")
+	require.Eventually(t, func() bool { return loads.Load() == 2 }, time.Second, time.Millisecond)
+
+	// a failed refresh is not retried by every read, only after RefreshAfterWrite
+	for i := 0; i < 20; i++ {
+		v, ok := cache.Get("foo")
+		require.True(t, ok)
+		require.Equal(t, 1, v)
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, int32(2), loads.Load())
+
+	clock.Advance(2 * time.Minute)
+	require.Eventually(t, func() bool {
+		cache.Get("foo")
+		return loads.Load() == 3
+	}, time.Second, time.Millisecond)
+}
+
+func TestLoadingCache_StaleWhileRevalidate(t *testing.T) {
+	var version atomic.Int32
+	release := make(chan struct{}, 1)
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		ExpireAfterWrite(20 * time.Millisecond).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			if v := version.Add(1); v > 1 {
+				<-release
+				return int(v), nil
+			}
+			return 1, nil
+		}).
+		StaleWhileRevalidate(time.Minute).
+		Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	v, stale, err := cache.GetOrLoadStale(context.Background(), "foo")
+	require.Nil(t, err)
+	require.False(t, stale)
+	require.Equal(t, 1, v)
+
+	time.Sleep(30 * time.Millisecond)
+	_, ok := cache.Get("foo")
+	require.False(t, ok)
+	for i := 0; i < 10; i++ {
+		v, stale, err = cache.GetOrLoadStale(context.Background(), "foo")
+		require.Nil(t, err)
+		require.True(t, stale)
+		require.Equal(t, 1, v)
+	}
+	require.Eventually(t, func() bool { return version.Load() == 2 }, time.Second, time.Millisecond)
+	release <- struct{}{}
+
+	require.Eventually(t, func() bool {
+		v, stale, err := cache.GetOrLoadStale(context.Background(), "foo")
+		return err == nil && !stale && v == 2
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int32(2), version.Load())
+}
+
+func TestLoadingCache_StaleWhileRevalidateKeepsTTL(t *testing.T) {
+	var loads atomic.Int32
+	clock := wtlfu.NewFakeClock(time.Now())
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Clock(clock).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			return int(loads.Add(1)) + 1, nil
+		}).
+		StaleWhileRevalidate(time.Minute).
+		Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	cache.SetWithOptions("foo", 1, wtlfu.SetOptions{TTL: time.Minute, Force: true})
+	clock.Advance(90 * time.Second)
+	v, stale, err := cache.GetOrLoadStale(context.Background(), "foo")
+	require.Nil(t, err)
+	require.True(t, stale)
+	require.Equal(t, 1, v)
+	require.Eventually(t, func() bool {
+		v, stale, err := cache.GetOrLoadStale(context.Background(), "foo")
+		return err == nil && !stale && v == 2
+	}, time.Second, time.Millisecond)
+
+	// the refreshed value keeps the ttl of SetWithTTL instead of never expiring
+	clock.Advance(90 * time.Second)
+	v, stale, err = cache.GetOrLoadStale(context.Background(), "foo")
+	require.Nil(t, err)
+	require.True(t, stale)
+	require.Equal(t, 2, v)
+	require.Eventually(t, func() bool { return loads.Load() == 2 }, time.Second, time.Millisecond)
+}
+
+func TestLoadingCache_StaleIfError(t *testing.T) {
+	errBackend := errors.New("backend down")
+	var loads atomic.Int32
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		ExpireAfterWrite(20 * time.Millisecond).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			if loads.Add(1) > 1 {
+				return 0, errBackend
+			}
+			return 1, nil
+		}).
+		StaleIfError(100 * time.Millisecond).
+		Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	_, err = cache.GetOrLoad(context.Background(), "foo")
+	require.Nil(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	v, stale, err := cache.GetOrLoadStale(context.Background(), "foo")
+	require.Nil(t, err)
+	require.True(t, stale)
+	require.Equal(t, 1, v)
+	require.Equal(t, int32(2), loads.Load())
+
+	// once the stale window has passed, the error is returned
+	time.Sleep(100 * time.Millisecond)
+	_, err = cache.GetOrLoad(context.Background(), "foo")
+	require.ErrorIs(t, err, errBackend)
+}
+
+func TestLoadingCache_LoadRacingClose(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	cache, err := wtlfu.NewBuilder[string, int](100).
+		Loading(func(ctx context.Context, key string) (int, error) {
+			started <- struct{}{}
+			<-release
+			return 1, nil
+		}).
+		BulkLoader(func(ctx context.Context, keys []string) (map[string]int, error) {
+			started <- struct{}{}
+			<-release
+			return map[string]int{keys[0]: 1}, nil
+		}).Build()
+	require.Nil(t, err)
+	cache.SetWithOptions("cached", 1, wtlfu.SetOptions{Force: true})
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := cache.GetOrLoad(context.Background(), "foo")
+		errs <- err
+	}()
+	go func() {
+		_, err := cache.GetAll(context.Background(), []string{"bar"})
+		errs <- err
+	}()
+	<-started
+	<-started
+
+	// loads finishing after Close started fail like the calls made after it
+	require.Nil(t, cache.Close(context.Background()))
+	close(release)
+	require.ErrorIs(t, <-errs, wtlfu.ErrClosed)
+	require.ErrorIs(t, <-errs, wtlfu.ErrClosed)
+	_, err = cache.GetOrLoad(context.Background(), "cached")
+	require.ErrorIs(t, err, wtlfu.ErrClosed)
+	_, ok := cache.Get("cached")
+	require.False(t, ok)
+}
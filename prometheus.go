@@ -0,0 +1,155 @@
+package wtlfu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"wtlfu/internal"
+)
+
+// Metrics is a snapshot of the counters and sizes of a cache, see Cache.Metrics.
+type Metrics = internal.Metrics
+
+// MetricsSource is implemented by Cache and LoadingCache.
+type MetricsSource interface {
+	Metrics() Metrics
+}
+
+// Metrics returns a snapshot of the counters and sizes of the cache. The
+// counters are zero unless stats are recorded, see Builder.RecordStats.
+func (c *Cache[K, V]) Metrics() Metrics {
+	return c.store.Metrics()
+}
+
+// MetricsHandler is an http.Handler rendering the metrics of registered caches
+// in the Prometheus text exposition format, every sample is labelled with the
+// name of its cache.
+type MetricsHandler struct {
+	mu      sync.RWMutex
+	sources map[string]MetricsSource
+}
+
+// NewMetricsHandler returns a MetricsHandler without any cache.
+func NewMetricsHandler() *MetricsHandler {
+	return &MetricsHandler{sources: make(map[string]MetricsSource)}
+}
+
+// Register adds a cache under name, which must be unique within the handler.
+func (h *MetricsHandler) Register(name string, source MetricsSource) error {
+	if name == "" {
+		return errors.New("wtlfu: metrics name must not be empty")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.sources[name]; ok {
+		return fmt.Errorf("wtlfu: metrics name %q already registered", name)
+	}
+	h.sources[name] = source
+	return nil
+}
+
+// Unregister removes the cache registered under name, if any.
+func (h *MetricsHandler) Unregister(name string) {
+	h.mu.Lock()
+	delete(h.sources, name)
+	h.mu.Unlock()
+}
+
+// metric is one family of the exposition, values returns its samples for a snapshot,
+// each labelled with label when it is set
+type metric struct {
+	name, help, typ string
+	label           string
+	values          func(m *Metrics) []sample
+}
+
+type sample struct {
+	label string
+	value float64
+}
+
+func single(v float64) []sample {
+	return []sample{{value: v}}
+}
+
+var metrics = []metric{
+	{"wtlfu_hits_total", "Number of reads that found their key.", "counter", "", func(m *Metrics) []sample {
+		return single(float64(m.Stats.Hits))
+	}},
+	{"wtlfu_misses_total", "Number of reads that did not find their key.", "counter", "", func(m *Metrics) []sample {
+		return single(float64(m.Stats.Misses))
+	}},
+	{"wtlfu_loads_total", "Number of loads by result.", "counter", "result", func(m *Metrics) []sample {
+		return []sample{{"success", float64(m.Stats.LoadSuccesses)}, {"failure", float64(m.Stats.LoadFailures)}}
+	}},
+	{"wtlfu_load_seconds_total", "Time spent loading.", "counter", "", func(m *Metrics) []sample {
+		return single(m.Stats.TotalLoadTime.Seconds())
+	}},
+	{"wtlfu_removals_total", "Number of entries that left the cache by reason.", "counter", "reason", func(m *Metrics) []sample {
+		samples := make([]sample, len(m.Stats.Removals))
+		for i, n := range m.Stats.Removals {
+			samples[i] = sample{RemoveReason(i).String(), float64(n)}
+		}
+		return samples
+	}},
+	{"wtlfu_rejections_total", "Number of writes not admitted by stage.", "counter", "stage", func(m *Metrics) []sample {
+		return []sample{{"doorkeeper", float64(m.Stats.DoorkeeperRejections)}, {"admission", float64(m.Stats.AdmissionRejections)}}
+	}},
+	{"wtlfu_entries", "Number of entries, including expired entries not cleaned up yet.", "gauge", "", func(m *Metrics) []sample {
+		return single(float64(m.Len))
+	}},
+	{"wtlfu_segment_entries", "Number of entries by policy segment.", "gauge", "segment", func(m *Metrics) []sample {
+		return []sample{{"window", float64(m.WindowLen)}, {"probation", float64(m.ProbationLen)}, {"protected", float64(m.ProtectedLen)}}
+	}},
+	{"wtlfu_timer_wheel_entries", "Number of entries scheduled by timer wheel level.", "gauge", "level", func(m *Metrics) []sample {
+		samples := make([]sample, len(m.TimerWheelLen))
+		for i, n := range m.TimerWheelLen {
+			samples[i] = sample{strconv.Itoa(i), float64(n)}
+		}
+		return samples
+	}},
+}
+
+// ServeHTTP writes the metrics of every registered cache, sorted by name.
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.sources))
+	for name := range h.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	snapshots := make([]Metrics, len(names))
+	for i, name := range names {
+		snapshots[i] = h.sources[name].Metrics()
+	}
+	h.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for i, name := range names {
+			for _, s := range m.values(&snapshots[i]) {
+				fmt.Fprintf(bw, "%s{cache=\"%s\"", m.name, escapeLabel(name))
+				if m.label != "" {
+					fmt.Fprintf(bw, ",%s=\"%s\"", m.label, s.label)
+				}
+				fmt.Fprintf(bw, "} %s\n", strconv.FormatFloat(s.value, 'g', -1, 64))
+			}
+		}
+	}
+	bw.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value as required by the text exposition format
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
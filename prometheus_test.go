@@ -0,0 +1,64 @@
+package wtlfu_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"wtlfu"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	users, err := wtlfu.NewBuilder[string, int](100).RecordStats().Build()
+	require.Nil(t, err)
+	defer users.Close(context.Background())
+	pages, err := wtlfu.NewBuilder[int, string](100).RecordStats().
+		Loading(func(ctx context.Context, key int) (string, error) {
+			return "page", nil
+		}).Build()
+	require.Nil(t, err)
+	defer pages.Close(context.Background())
+
+	handler := wtlfu.NewMetricsHandler()
+	require.Nil(t, handler.Register("users", users))
+	require.Nil(t, handler.Register(`pa"ges`, pages))
+	require.NotNil(t, handler.Register("users", users))
+	require.NotNil(t, handler.Register("", users))
+
+	set(users, "foo", 1)
+	users.Get("foo")
+	users.Get("bar")
+	_, err = pages.GetOrLoad(context.Background(), 1)
+	require.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE wtlfu_hits_total counter",
+		`wtlfu_hits_total{cache="users"} 1`,
+		`wtlfu_misses_total{cache="users"} 1`,
+		`wtlfu_misses_total{cache="pa\"ges"} 1`,
+		`wtlfu_loads_total{cache="pa\"ges",result="success"} 1`,
+		`wtlfu_removals_total{cache="users",reason="evicted"} 0`,
+		`wtlfu_rejections_total{cache="users",stage="doorkeeper"} 1`,
+		"# TYPE wtlfu_entries gauge",
+		`wtlfu_entries{cache="users"} 1`,
+		`wtlfu_segment_entries{cache="users",segment="window"} 1`,
+		`wtlfu_timer_wheel_entries{cache="users",level="0"} 0`,
+	} {
+		require.Contains(t, body, line+"\n")
+	}
+	// the families are written once, with the caches sorted by name
+	require.Equal(t, 1, strings.Count(body, "# TYPE wtlfu_hits_total"))
+	require.Less(t, strings.Index(body, `wtlfu_hits_total{cache="pa\"ges"}`), strings.Index(body, `wtlfu_hits_total{cache="users"}`))
+
+	handler.Unregister("users")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.NotContains(t, rec.Body.String(), `cache="users"`)
+}
@@ -0,0 +1,25 @@
+package wtlfu
+
+import "wtlfu/internal"
+
+// Stats is a snapshot of the counters of a cache, see Builder.RecordStats.
+// Removals is indexed by RemoveReason, Removed reads it. Use Minus on two
+// snapshots to get the counts of the period between them.
+type Stats = internal.Stats
+
+// StatsCounter records the events of a cache. Implementations must be safe
+// for concurrent use and return quickly, they are called on every read.
+type StatsCounter = internal.StatsCounter
+
+// NewStatsCounter returns the StatsCounter used by Builder.RecordStats. It
+// spreads its counters over several stripes so concurrent reads do not
+// contend on the same memory, at the cost of a slower Snapshot.
+func NewStatsCounter() StatsCounter {
+	return internal.NewStatsCounter()
+}
+
+// Stats returns a snapshot of the counters of the cache, all zero unless
+// stats are recorded.
+func (c *Cache[K, V]) Stats() Stats {
+	return c.store.Stats()
+}
@@ -0,0 +1,88 @@
+package wtlfu_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"wtlfu"
+)
+
+func TestCache_Stats(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).RecordStats().Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+	require.Equal(t, wtlfu.Stats{}, cache.Stats())
+
+	// the first write is rejected by the doorkeeper
+	require.False(t, cache.Set("foo", 1))
+	require.True(t, cache.Set("foo", 1))
+	cache.Get("foo")
+	cache.Get("bar")
+	before := cache.Stats()
+	require.Equal(t, uint64(1), before.Hits)
+	require.Equal(t, uint64(1), before.Misses)
+	require.Equal(t, uint64(1), before.DoorkeeperRejections)
+	require.Equal(t, 0.5, before.HitRatio())
+
+	cache.Get("foo")
+	cache.Delete("foo")
+	require.Eventually(t, func() bool {
+		return cache.Stats().Removed(wtlfu.Removed) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	delta := cache.Stats().Minus(before)
+	require.Equal(t, uint64(1), delta.Hits)
+	require.Equal(t, uint64(0), delta.Misses)
+	require.Equal(t, uint64(1), delta.Removed(wtlfu.Removed))
+	require.Equal(t, wtlfu.Stats{}, before.Minus(cache.Stats()))
+
+	for i := 0; i < 1000; i++ {
+		set(cache, strconv.Itoa(i), i)
+	}
+	require.Eventually(t, func() bool {
+		stats := cache.Stats()
+		return stats.Removed(wtlfu.Evicted) > 0 && stats.AdmissionRejections > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCache_StatsDisabled(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	set(cache, "foo", 1)
+	cache.Get("foo")
+	require.Equal(t, wtlfu.Stats{}, cache.Stats())
+}
+
+func TestLoadingCache_Stats(t *testing.T) {
+	cache, err := wtlfu.NewBuilder[string, int](100).RecordStats().
+		Loading(func(ctx context.Context, key string) (int, error) {
+			time.Sleep(time.Millisecond)
+			if key == "fail" {
+				return 0, errors.New("load failed")
+			}
+			return 1, nil
+		}).Build()
+	require.Nil(t, err)
+	defer cache.Close(context.Background())
+
+	_, err = cache.GetOrLoad(context.Background(), "foo")
+	require.Nil(t, err)
+	_, err = cache.GetOrLoad(context.Background(), "foo")
+	require.Nil(t, err)
+	_, err = cache.GetOrLoad(context.Background(), "fail")
+	require.NotNil(t, err)
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, uint64(2), stats.Misses)
+	require.Equal(t, uint64(1), stats.LoadSuccesses)
+	require.Equal(t, uint64(1), stats.LoadFailures)
+	require.GreaterOrEqual(t, stats.AverageLoadPenalty(), time.Millisecond)
+}